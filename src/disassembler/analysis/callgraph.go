@@ -0,0 +1,324 @@
+package analysis
+
+import (
+	"disassembler/datatypes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Function, or imported function, in the call graph.
+type Node struct {
+	Addr     int
+	Name     string
+	Import   bool
+	Function *Function // nil for imports and targets outside any function.
+	Calls    []*Call
+	Callers  []*Call
+}
+
+// Call site. Callee is nil if the call is indirect and its target unknown.
+type Call struct {
+	Site   int
+	Text   string
+	Caller *Node
+	Callee *Node
+}
+
+// Call graph of a listing's functions, with nodes by address.
+type CallGraph struct {
+	Nodes  map[int]*Node
+	sorted []*Node
+}
+
+// Build the call graph of the functions. Imports gives the names of imported
+// functions by the address of their stubs and import slots, or where calls
+// to them resolve, so that calls to them, direct or through a slot, end at a
+// leaf node. Imports of the same name are one node. Text renders a call
+// site's instruction.
+func BuildCallGraph(functions []*Function, imports map[int]string, text func(*datatypes.Instruction) string) *CallGraph {
+	cg := &CallGraph{
+		Nodes: make(map[int]*Node),
+	}
+	named := make(map[string]*Node)
+
+	for _, fn := range functions {
+		node := cg.node(fn.Entry, fn.Name)
+		node.Function = fn
+		_, node.Import = imports[fn.Entry]
+	}
+
+	for _, fn := range functions {
+		caller := cg.Nodes[fn.Entry]
+		if caller.Import {
+			continue
+		}
+
+		for _, block := range fn.Blocks {
+			for _, instruction := range block.Instructions {
+				if !IsCall(instruction) {
+					continue
+				}
+
+				call := &Call{
+					Site:   instruction.Offset,
+					Text:   strings.TrimSpace(text(instruction)),
+					Caller: caller,
+				}
+
+				// Indirect calls only resolve through an import slot.
+				target, ok := callTarget(instruction)
+				if _, imported := imports[target]; !instruction.HasTarget && !imported {
+					ok = false
+				}
+
+				if ok {
+					callee, exists := cg.Nodes[target]
+					name, imported := imports[target]
+					if !exists && imported {
+						callee, exists = named[name]
+					}
+					if !exists {
+						if !imported {
+							name = fmt.Sprintf("sub_%08x", target)
+						}
+						callee = cg.node(target, name)
+						callee.Import = imported
+						if imported {
+							named[name] = callee
+						}
+					}
+					call.Callee = callee
+					callee.Callers = append(callee.Callers, call)
+				}
+
+				caller.Calls = append(caller.Calls, call)
+			}
+		}
+	}
+
+	for _, node := range cg.Nodes {
+		cg.sorted = append(cg.sorted, node)
+	}
+	sort.Slice(cg.sorted, func(i, j int) bool { return cg.sorted[i].Addr < cg.sorted[j].Addr })
+
+	return cg
+}
+
+func (cg *CallGraph) node(addr int, name string) *Node {
+	node := &Node{
+		Addr: addr,
+		Name: name,
+	}
+	cg.Nodes[addr] = node
+	return node
+}
+
+// Target of a call: the destination of a direct call, or for an indirect
+// call through an absolute address, "call [addr]", the address it reads. A
+// direct call left to a relocation still resolves to wherever its field
+// points.
+func callTarget(instruction *datatypes.Instruction) (int, bool) {
+	if instruction.HasTarget || instruction.Op == 0xE8 {
+		return instruction.Target, true
+	}
+	return MemoryAddress(instruction)
+}
+
+// Nodes in address order.
+func (cg *CallGraph) Sorted() []*Node {
+	return cg.sorted
+}
+
+// Find a node by name, or by address in hex.
+func (cg *CallGraph) Find(name string) *Node {
+	for _, node := range cg.sorted {
+		if node.Name == name {
+			return node
+		}
+	}
+
+	var addr int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(strings.ToLower(name), "0x"), "%x", &addr); err == nil {
+		return cg.Nodes[addr]
+	}
+	return nil
+}
+
+// Every path of calls from one node to another that doesn't visit a node
+// twice, up to limit paths. Only nodes that can reach the last are walked,
+// so the search doesn't wander the rest of a large graph.
+func (cg *CallGraph) Paths(from *Node, to *Node, limit int) [][]*Node {
+	var paths [][]*Node
+	var path []*Node
+	on_path := make(map[*Node]bool)
+	reaches := cg.reaching(to)
+
+	var walk func(node *Node)
+	walk = func(node *Node) {
+		if len(paths) >= limit || on_path[node] || !reaches[node] {
+			return
+		}
+
+		path = append(path, node)
+		on_path[node] = true
+
+		if node == to {
+			paths = append(paths, append([]*Node(nil), path...))
+		} else {
+			seen := make(map[*Node]bool)
+			for _, call := range node.Calls {
+				if call.Callee != nil && !seen[call.Callee] {
+					seen[call.Callee] = true
+					walk(call.Callee)
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		on_path[node] = false
+	}
+
+	walk(from)
+	return paths
+}
+
+// Nodes that can reach to by calls, to itself included.
+func (cg *CallGraph) reaching(to *Node) map[*Node]bool {
+	reaches := map[*Node]bool{to: true}
+	pending := []*Node{to}
+
+	for len(pending) > 0 {
+		node := pending[0]
+		pending = pending[1:]
+		for _, call := range node.Callers {
+			if !reaches[call.Caller] {
+				reaches[call.Caller] = true
+				pending = append(pending, call.Caller)
+			}
+		}
+	}
+
+	return reaches
+}
+
+// Functions nothing calls, from which paths of calls can start.
+func (cg *CallGraph) Roots() []*Node {
+	var roots []*Node
+	for _, node := range cg.sorted {
+		if node.Function != nil && !node.Import && len(node.Callers) == 0 {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// Write the call graph as text: each function, with its call sites.
+func (cg *CallGraph) WriteText(w io.Writer) {
+	for _, node := range cg.sorted {
+		if node.Function == nil && len(node.Callers) == 0 {
+			continue
+		}
+
+		kind := ""
+		if node.Import {
+			kind = ", import"
+		}
+		fmt.Fprintf(w, "%s (%08x%s)\n", node.Name, node.Addr, kind)
+
+		for _, call := range node.Calls {
+			if call.Callee == nil {
+				fmt.Fprintf(w, "\t%08x\t%s\t; unresolved\n", call.Site, call.Text)
+			} else {
+				fmt.Fprintf(w, "\t%08x\t%s\n", call.Site, call.Callee.Name)
+			}
+		}
+	}
+}
+
+// Write the call graph as a Graphviz DOT digraph. Imports are dashed boxes,
+// and unresolved calls lead to a "?" of their own.
+func (cg *CallGraph) WriteDOT(w io.Writer) {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	fmt.Fprintf(w, "digraph callgraph {\n")
+	fmt.Fprintf(w, "\tnode [shape=box fontname=\"monospace\"];\n")
+
+	for _, node := range cg.sorted {
+		style := ""
+		if node.Import {
+			style = " style=dashed"
+		}
+		fmt.Fprintf(w, "\tf_%08x [label=\"%s\"%s];\n", node.Addr, escape.Replace(node.Name), style)
+	}
+
+	for _, node := range cg.sorted {
+		seen := make(map[*Node]bool)
+		for _, call := range node.Calls {
+			switch {
+			case call.Callee == nil:
+				fmt.Fprintf(w, "\tu_%08x [label=\"?\" shape=plaintext];\n", call.Site)
+				fmt.Fprintf(w, "\tf_%08x -> u_%08x [style=dashed tooltip=\"%s\"];\n", node.Addr, call.Site, escape.Replace(call.Text))
+			case !seen[call.Callee]:
+				seen[call.Callee] = true
+				fmt.Fprintf(w, "\tf_%08x -> f_%08x;\n", node.Addr, call.Callee.Addr)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "}\n")
+}
+
+// Write the call graph as JSON: its functions, and its call sites.
+func (cg *CallGraph) WriteJSON(w io.Writer) error {
+	type jsonFunction struct {
+		Name   string `json:"name"`
+		Addr   int    `json:"addr"`
+		End    int    `json:"end,omitempty"`
+		Import bool   `json:"import,omitempty"`
+	}
+	type jsonCall struct {
+		Site   int     `json:"site"`
+		Text   string  `json:"text"`
+		Caller string  `json:"caller"`
+		Callee *string `json:"callee"` // null if unresolved.
+	}
+
+	out := struct {
+		Functions []jsonFunction `json:"functions"`
+		Calls     []jsonCall     `json:"calls"`
+	}{
+		Functions: []jsonFunction{},
+		Calls:     []jsonCall{},
+	}
+
+	for _, node := range cg.sorted {
+		fn := jsonFunction{
+			Name:   node.Name,
+			Addr:   node.Addr,
+			Import: node.Import,
+		}
+		if node.Function != nil {
+			fn.End = node.Function.End
+		}
+		out.Functions = append(out.Functions, fn)
+
+		for _, call := range node.Calls {
+			c := jsonCall{
+				Site:   call.Site,
+				Text:   call.Text,
+				Caller: node.Name,
+			}
+			if call.Callee != nil {
+				c.Callee = &call.Callee.Name
+			}
+			out.Calls = append(out.Calls, c)
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
@@ -0,0 +1,199 @@
+package analysis
+
+import (
+	"disassembler/datatypes"
+	"sort"
+	"strings"
+)
+
+// Kinds of control flow edge
+const (
+	EDGE_FALLTHROUGH = "fallthrough"
+	EDGE_TAKEN       = "taken" // Conditional jump
+	EDGE_JUMP        = "jump"  // Unconditional jump
+	EDGE_CASE        = "case"  // Indirect jump through a switch's jump table
+	EDGE_CALL        = "call"
+)
+
+// Control flow edge from the block at From to the code at To.
+type Edge struct {
+	From int
+	To   int
+	Kind string
+}
+
+// Basic block: a run of instructions only entered at the top and only left
+// at the bottom, calls aside.
+type Block struct {
+	Start        int
+	End          int // Offset after the last instruction.
+	Instructions []*datatypes.Instruction
+	Succs        []*Edge
+	Preds        []*Edge
+	Calls        []*Edge
+}
+
+// Control flow graph of a listing, with blocks by start offset.
+type CFG struct {
+	Blocks map[int]*Block
+	sorted []*Block
+}
+
+// Is instruction a call?
+func IsCall(instruction *datatypes.Instruction) bool {
+	return instruction.Mnemonic == "call"
+}
+
+// Is instruction an unconditional jump?
+func IsJump(instruction *datatypes.Instruction) bool {
+	return instruction.Mnemonic == "jmp"
+}
+
+// Is instruction a conditional jump?
+func IsConditional(instruction *datatypes.Instruction) bool {
+	return strings.HasPrefix(instruction.Mnemonic, "j") && !IsJump(instruction)
+}
+
+// Is instruction a return?
+func IsReturn(instruction *datatypes.Instruction) bool {
+	return instruction.Mnemonic == "retn" || instruction.Mnemonic == "retf"
+}
+
+// Is instruction padding between functions: nop, int3 or zeroes?
+func IsPadding(instruction *datatypes.Instruction) bool {
+	if len(instruction.Literal) == 0 {
+		return false
+	}
+	for _, b := range instruction.Literal {
+		if b != 0x90 && b != 0xCC && b != 0x00 {
+			return false
+		}
+	}
+	return true
+}
+
+// Directives the listing uses for data
+var Directives = map[string]bool{
+	"db":    true,
+	"dw":    true,
+	"dd":    true,
+	"dq":    true,
+	"align": true,
+}
+
+// Is instruction code, rather than data or a bare label?
+func IsCode(instruction *datatypes.Instruction) bool {
+	return len(instruction.Literal) > 0 && !Directives[instruction.Mnemonic]
+}
+
+// Build the control flow graph of the decoded instructions, by offset. Blocks
+// start at labels, branch targets and switch cases, after branches, returns
+// and padding, and at the start of each run of code, and calls don't end them.
+func BuildCFG(instructions map[int]*datatypes.Instruction) *CFG {
+	var offsets []int
+	for offset, instruction := range instructions {
+		if IsCode(instruction) {
+			offsets = append(offsets, offset)
+		}
+	}
+	sort.Ints(offsets)
+
+	leaders := make(map[int]bool)
+	for i, offset := range offsets {
+		instruction := instructions[offset]
+
+		if instruction.Label != "" {
+			leaders[offset] = true
+		}
+		if instruction.HasTarget {
+			leaders[instruction.Target] = true
+		}
+		for _, target := range instruction.Cases {
+			leaders[target] = true
+		}
+		if i == 0 || offsets[i-1]+len(instructions[offsets[i-1]].Literal) != offset {
+			leaders[offset] = true
+		} else if IsPadding(instructions[offsets[i-1]]) && !IsPadding(instruction) {
+			leaders[offset] = true
+		}
+		if IsJump(instruction) || IsConditional(instruction) || IsReturn(instruction) {
+			leaders[offset+len(instruction.Literal)] = true
+		}
+	}
+
+	g := &CFG{
+		Blocks: make(map[int]*Block),
+	}
+
+	var block *Block
+	for _, offset := range offsets {
+		instruction := instructions[offset]
+
+		if block == nil || leaders[offset] {
+			block = &Block{
+				Start: offset,
+			}
+			g.Blocks[offset] = block
+		}
+		block.Instructions = append(block.Instructions, instruction)
+		block.End = offset + len(instruction.Literal)
+	}
+
+	for _, block := range g.Blocks {
+		g.sorted = append(g.sorted, block)
+	}
+	sort.Slice(g.sorted, func(i, j int) bool { return g.sorted[i].Start < g.sorted[j].Start })
+
+	for _, block := range g.sorted {
+		for _, instruction := range block.Instructions {
+			if IsCall(instruction) && instruction.HasTarget {
+				block.Calls = append(block.Calls, &Edge{block.Start, instruction.Target, EDGE_CALL})
+			}
+		}
+
+		last := block.Instructions[len(block.Instructions)-1]
+
+		if IsConditional(last) && last.HasTarget {
+			g.addEdge(block, last.Target, EDGE_TAKEN)
+		}
+		if IsJump(last) && last.HasTarget {
+			g.addEdge(block, last.Target, EDGE_JUMP)
+		}
+		seen := make(map[int]bool)
+		for _, target := range last.Cases {
+			if !seen[target] {
+				seen[target] = true
+				g.addEdge(block, target, EDGE_CASE)
+			}
+		}
+		if !IsJump(last) && !IsReturn(last) {
+			if _, exists := g.Blocks[block.End]; exists {
+				g.addEdge(block, block.End, EDGE_FALLTHROUGH)
+			}
+		}
+	}
+
+	return g
+}
+
+func (g *CFG) addEdge(block *Block, to int, kind string) {
+	edge := &Edge{block.Start, to, kind}
+	block.Succs = append(block.Succs, edge)
+	if succ, exists := g.Blocks[to]; exists {
+		succ.Preds = append(succ.Preds, edge)
+	}
+}
+
+// Blocks in offset order.
+func (g *CFG) Sorted() []*Block {
+	return g.sorted
+}
+
+// Block containing offset, or nil.
+func (g *CFG) BlockAt(offset int) *Block {
+	i := sort.Search(len(g.sorted), func(i int) bool { return g.sorted[i].End > offset })
+	if i < len(g.sorted) && g.sorted[i].Start <= offset {
+		return g.sorted[i]
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package analysis
+
+import (
+	"disassembler/datatypes"
+)
+
+// Calling conventions
+const (
+	CONV_CDECL    = "cdecl"    // Arguments on the stack, which the caller cleans up.
+	CONV_STDCALL  = "stdcall"  // Arguments on the stack, which the callee pops.
+	CONV_FASTCALL = "fastcall" // The first two in ecx and edx, the rest as stdcall.
+	CONV_THISCALL = "thiscall" // The object in ecx, the rest as stdcall.
+)
+
+// Calling convention of a function, and how many arguments it takes, on the
+// stack and in registers.
+type Convention struct {
+	Name      string
+	Args      int
+	StackArgs int
+	Registers datatypes.RegSet
+}
+
+// Bytes each call site's caller cleans up off the stack after calling, by
+// the entry of the function called: the "add esp, imm" that follows the call,
+// or the "pop ecx" or "pop ecx; pop ecx" that compilers use for a word or two.
+func (g *CFG) Cleanups() map[int][]int {
+	cleanups := make(map[int][]int)
+
+	for _, block := range g.sorted {
+		for i, instruction := range block.Instructions {
+			if !IsCall(instruction) || !instruction.HasTarget {
+				continue
+			}
+
+			// The cleanup starts the next block if something branches to it.
+			next := block.Instructions[i+1:]
+			if len(next) == 0 {
+				if after, exists := g.Blocks[block.End]; exists {
+					next = after.Instructions
+				}
+			}
+
+			if size := cleanup(next); size > 0 {
+				cleanups[instruction.Target] = append(cleanups[instruction.Target], size)
+			}
+		}
+	}
+
+	return cleanups
+}
+
+// Bytes the instructions after a call clean up off the stack, or 0 if they
+// don't start with a cleanup.
+func cleanup(instructions []*datatypes.Instruction) int {
+	if len(instructions) > 0 && isAdjustment(instructions[0]) {
+		size, _ := datatypes.BytesToIntSigned(instructions[0].Immediate)
+		return size
+	}
+
+	size := 0
+	for _, instruction := range instructions {
+		if !isScratchPop(instruction) {
+			break
+		}
+		size += 4
+	}
+	return size
+}
+
+// Is instruction "add esp, imm"?
+func isAdjustment(instruction *datatypes.Instruction) bool {
+	modrm := instruction.Modrm
+	return instruction.Mnemonic == "add" && modrm != nil && modrm.Mod == datatypes.AM_DIRECT &&
+		modrm.RM == datatypes.REG_ESP && len(instruction.Immediate) > 0
+}
+
+// Is instruction "pop ecx" or "pop edx", popping into a register the call has
+// already clobbered? Pops of anything else restore what was saved, and eax
+// holds what the call returned.
+func isScratchPop(instruction *datatypes.Instruction) bool {
+	return instruction.Mnemonic == "pop" &&
+		(instruction.Op == 0x58+byte(datatypes.REG_ECX) || instruction.Op == 0x58+byte(datatypes.REG_EDX))
+}
+
+// Registers fn reads on some path from its entry before writing them, so
+// that it must be given them by its caller. The registers it pushes aren't
+// counted, since a push of ecx is as often just room for a local.
+func (fn *Function) Inputs() datatypes.RegSet {
+	blocks := make(map[int]*Block)
+	for _, block := range fn.Blocks {
+		blocks[block.Start] = block
+	}
+	if blocks[fn.Entry] == nil {
+		return 0
+	}
+
+	// Registers written on every path to each block's start.
+	written := map[int]datatypes.RegSet{fn.Entry: 0}
+	pending := []int{fn.Entry}
+	var inputs datatypes.RegSet
+
+	for len(pending) > 0 {
+		block := blocks[pending[0]]
+		pending = pending[1:]
+
+		defined := written[block.Start]
+		for _, instruction := range block.Instructions {
+			s := instruction.Semantics
+			if s == nil {
+				continue
+			}
+			if instruction.Mnemonic != "push" {
+				inputs |= s.Reads &^ defined
+			}
+			defined |= s.Writes
+			if IsCall(instruction) {
+				defined |= CALLER_SAVED
+			}
+		}
+
+		for _, edge := range block.Succs {
+			if blocks[edge.To] == nil {
+				continue
+			}
+			before, visited := written[edge.To]
+			merged := defined
+			if visited {
+				merged &= before
+			}
+			if !visited || merged != before {
+				written[edge.To] = merged
+				pending = append(pending, edge.To)
+			}
+		}
+	}
+
+	return inputs
+}
+
+// Work out fn's calling convention, from whether it pops its arguments on
+// return, whether its callers clean them up, as cleanups says, and whether
+// it's given ecx and edx. frame gives how far into the arguments it reaches,
+// for when neither it nor its callers pop them, as when they're moved onto
+// the stack instead of pushed.
+func InferConvention(fn *Function, frame *Frame, cleanups map[int][]int) *Convention {
+	conv := &Convention{Name: CONV_CDECL}
+
+	if frame != nil {
+		for _, v := range frame.Vars {
+			if args := (v.Offset-4)/4 + 1; v.Offset >= 4 && args > conv.StackArgs {
+				conv.StackArgs = args
+			}
+		}
+	}
+	for _, size := range cleanups[fn.Entry] {
+		if size/4 > conv.StackArgs {
+			conv.StackArgs = size / 4
+		}
+	}
+
+	// The callee knows best how many arguments it pops.
+	pops := fn.Pops()
+	if pops > 0 {
+		conv.Name = CONV_STDCALL
+		conv.StackArgs = pops / 4
+	}
+
+	// Callers cleaning up makes it cdecl, whatever's left in ecx and edx.
+	inputs := fn.Inputs()
+	ecx, edx := inputs.Has(datatypes.REG_ECX), inputs.Has(datatypes.REG_EDX)
+	switch {
+	case len(cleanups[fn.Entry]) > 0:
+	case edx:
+		conv.Name = CONV_FASTCALL
+		conv.Registers = datatypes.RegSetOf(datatypes.REG_ECX, datatypes.REG_EDX)
+	case ecx:
+		conv.Name = CONV_THISCALL
+		conv.Registers = datatypes.RegSetOf(datatypes.REG_ECX)
+	}
+
+	conv.Args = conv.StackArgs + len(conv.Registers.Registers())
+	return conv
+}
@@ -0,0 +1,152 @@
+package analysis
+
+// Kinds of data
+const (
+	DATA_BYTES    = "bytes"
+	DATA_ASCII    = "ascii"
+	DATA_UTF16    = "utf16" // UTF-16LE string
+	DATA_POINTERS = "pointers"
+	DATA_PADDING  = "padding"
+)
+
+// Fewest characters taken for a string.
+const MIN_STRING = 5
+
+// Fewest pointers in a row taken for an array of them.
+const MIN_POINTERS = 2
+
+// Run of data bytes of one kind, at Offset.
+type Data struct {
+	Offset     int
+	Bytes      []byte
+	Kind       string
+	Text       string // Contents of a string.
+	Terminated bool   // if it ends in a NUL.
+	Pointers   []int  // Entries of a pointer array.
+}
+
+// Split data, which is at offset, into strings, arrays of pointers of
+// ptrsize bytes that mapped says are in the image, runs of padding, and
+// the bytes in between.
+func ClassifyData(data []byte, offset int, ptrsize int, mapped func(int) bool) []*Data {
+	var items []*Data
+	var bytes *Data
+
+	for i := 0; i < len(data); {
+		item := dataAt(data[i:], offset+i, ptrsize, mapped)
+		if item == nil {
+			if bytes == nil {
+				bytes = &Data{Offset: offset + i, Kind: DATA_BYTES}
+				items = append(items, bytes)
+			}
+			bytes.Bytes = append(bytes.Bytes, data[i])
+			i++
+			continue
+		}
+
+		bytes = nil
+		items = append(items, item)
+		i += len(item.Bytes)
+	}
+
+	return items
+}
+
+// Data item at the start of data, or nil if it's just bytes.
+func dataAt(data []byte, offset int, ptrsize int, mapped func(int) bool) *Data {
+	if text, size, kind := StringAt(data); size > 0 {
+		width := 1
+		if kind == DATA_UTF16 {
+			width = 2
+		}
+		return &Data{
+			Offset:     offset,
+			Bytes:      data[:size],
+			Kind:       kind,
+			Text:       text,
+			Terminated: size > width*len(text),
+		}
+	}
+
+	if offset%ptrsize == 0 {
+		var pointers []int
+		for i := 0; i+ptrsize <= len(data); i += ptrsize {
+			pointer := readPointer(data[i:], ptrsize)
+			if pointer == 0 || !mapped(pointer) {
+				break
+			}
+			pointers = append(pointers, pointer)
+		}
+		if len(pointers) >= MIN_POINTERS {
+			return &Data{
+				Offset:   offset,
+				Bytes:    data[:len(pointers)*ptrsize],
+				Kind:     DATA_POINTERS,
+				Pointers: pointers,
+			}
+		}
+	}
+
+	if b := data[0]; b == 0x00 || b == 0x90 || b == 0xCC {
+		size := 1
+		for size < len(data) && data[size] == b {
+			size++
+		}
+		if size > 1 {
+			return &Data{
+				Offset: offset,
+				Bytes:  data[:size],
+				Kind:   DATA_PADDING,
+			}
+		}
+	}
+
+	return nil
+}
+
+// String at the start of data, ASCII or UTF-16LE, of at least MIN_STRING
+// printable characters ending in a NUL or at the end of data. Returns its
+// text, its size in bytes with the NUL, and its kind, or a size of 0 if
+// there's no string.
+func StringAt(data []byte) (string, int, string) {
+	var text []byte
+
+	for i := 0; i < len(data) && isPrintable(data[i]); i++ {
+		text = append(text, data[i])
+	}
+	if len(text) >= MIN_STRING {
+		switch {
+		case len(text) == len(data):
+			return string(text), len(text), DATA_ASCII
+		case data[len(text)] == 0:
+			return string(text), len(text) + 1, DATA_ASCII
+		}
+	}
+
+	text = nil
+	for i := 0; i+1 < len(data) && isPrintable(data[i]) && data[i+1] == 0; i += 2 {
+		text = append(text, data[i])
+	}
+	if size := 2 * len(text); len(text) >= MIN_STRING {
+		switch {
+		case size+1 >= len(data):
+			return string(text), size, DATA_UTF16
+		case data[size] == 0 && data[size+1] == 0:
+			return string(text), size + 2, DATA_UTF16
+		}
+	}
+
+	return "", 0, ""
+}
+
+func isPrintable(b byte) bool {
+	return (b >= 0x20 && b < 0x7F) || b == '\t' || b == '\n' || b == '\r'
+}
+
+func readPointer(data []byte, size int) int {
+	var pointer int
+	for i := size - 1; i >= 0; i-- {
+		pointer = pointer<<8 | int(data[i])
+	}
+	return pointer
+}
@@ -0,0 +1,102 @@
+package analysis
+
+import (
+	"disassembler/datatypes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Edge colours, as IDA uses them
+const (
+	COLOR_TAKEN       = "green"
+	COLOR_NOT_TAKEN   = "red"
+	COLOR_JUMP        = "blue"
+	COLOR_FALLTHROUGH = "black"
+)
+
+// Colour of an edge: green or red for a conditional jump taken or not, and
+// blue for an unconditional one or a switch case.
+func (g *CFG) EdgeColor(edge *Edge) string {
+	switch edge.Kind {
+	case EDGE_TAKEN:
+		return COLOR_TAKEN
+	case EDGE_JUMP, EDGE_CASE:
+		return COLOR_JUMP
+	}
+
+	from := g.Blocks[edge.From]
+	if from != nil && IsConditional(from.Instructions[len(from.Instructions)-1]) {
+		return COLOR_NOT_TAKEN
+	}
+	return COLOR_FALLTHROUGH
+}
+
+// Lines of a block's label: its instructions as text renders them, headed by
+// the label of the first, if any.
+func blockLines(block *Block, text func(*datatypes.Instruction) string) []string {
+	var lines []string
+	if label := block.Instructions[0].Label; label != "" {
+		lines = append(lines, label+":")
+	}
+	for _, instruction := range block.Instructions {
+		lines = append(lines, fmt.Sprintf("%08x: %s", instruction.Offset, strings.TrimSpace(text(instruction))))
+	}
+	return lines
+}
+
+// Write fn's blocks and edges as a Graphviz DOT digraph.
+func (g *CFG) WriteDOT(w io.Writer, fn *Function, text func(*datatypes.Instruction) string) {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	fmt.Fprintf(w, "digraph \"%s\" {\n", escape.Replace(fn.Name))
+	fmt.Fprintf(w, "\tnode [shape=box fontname=\"monospace\"];\n")
+
+	for _, block := range fn.Blocks {
+		var label string
+		for _, line := range blockLines(block, text) {
+			label += escape.Replace(line) + `\l`
+		}
+		fmt.Fprintf(w, "\tb_%08x [label=\"%s\"];\n", block.Start, label)
+	}
+
+	for _, block := range fn.Blocks {
+		for _, edge := range block.Succs {
+			fmt.Fprintf(w, "\tb_%08x -> b_%08x [color=%s];\n", edge.From, edge.To, g.EdgeColor(edge))
+		}
+	}
+
+	fmt.Fprintf(w, "}\n")
+}
+
+// Write fn's blocks and edges as a Mermaid flowchart, fenced for Markdown so
+// that several can go in one file.
+func (g *CFG) WriteMermaid(w io.Writer, fn *Function, text func(*datatypes.Instruction) string) {
+	escape := strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;")
+
+	fmt.Fprintf(w, "```mermaid\n")
+	fmt.Fprintf(w, "%%%% %s\n", fn.Name)
+	fmt.Fprintf(w, "flowchart TD\n")
+
+	for _, block := range fn.Blocks {
+		var lines []string
+		for _, line := range blockLines(block, text) {
+			lines = append(lines, escape.Replace(line))
+		}
+		fmt.Fprintf(w, "\tb_%08x[\"%s\"]\n", block.Start, strings.Join(lines, "<br/>"))
+	}
+
+	// Links are styled by their index, in the order they're declared.
+	var colors []string
+	for _, block := range fn.Blocks {
+		for _, edge := range block.Succs {
+			fmt.Fprintf(w, "\tb_%08x -->|%s| b_%08x\n", edge.From, edge.Kind, edge.To)
+			colors = append(colors, g.EdgeColor(edge))
+		}
+	}
+	for i, color := range colors {
+		fmt.Fprintf(w, "\tlinkStyle %d stroke:%s\n", i, color)
+	}
+
+	fmt.Fprintf(w, "```\n")
+}
@@ -0,0 +1,213 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// How a function was found
+const (
+	FOUND_ENTRY    = "entry"
+	FOUND_SYMBOL   = "symbol"
+	FOUND_UNWIND   = "unwind"
+	FOUND_CALL     = "call"
+	FOUND_PROLOGUE = "prologue"
+	FOUND_PADDING  = "padding"
+)
+
+// Function: the blocks reachable from its entry without calls.
+type Function struct {
+	Name   string
+	Entry  int
+	End    int // Offset after the last instruction.
+	Found  string
+	Blocks []*Block // In offset order.
+}
+
+// Known function start, such as a symbol, with its name and end if known.
+type Hint struct {
+	Addr  int
+	End   int
+	Name  string
+	Found string
+}
+
+// Size of the function in bytes.
+func (fn *Function) Size() int {
+	return fn.End - fn.Entry
+}
+
+// Find the functions in the graph: those given by hints, in order of
+// preference, then call targets, blocks that start with a prologue and code
+// that follows padding after a return or jump. Each function's blocks stop
+// at the entries of the others, and it ends with the last of them before the
+// next function, unless a hint says where it ends.
+func FindFunctions(g *CFG, hints []Hint) []*Function {
+	found := make(map[int]*Hint)
+	add := func(hint Hint) {
+		if _, exists := g.Blocks[hint.Addr]; !exists {
+			return
+		}
+		if _, exists := found[hint.Addr]; !exists {
+			found[hint.Addr] = &hint
+		}
+	}
+
+	for _, hint := range hints {
+		add(hint)
+	}
+
+	for _, block := range g.sorted {
+		for _, edge := range block.Calls {
+			add(Hint{Addr: edge.To, Found: FOUND_CALL})
+		}
+	}
+
+	for i, block := range g.sorted {
+		if g.isReached(block) || IsPadding(block.Instructions[0]) {
+			continue
+		}
+		if HasPrologue(block) {
+			add(Hint{Addr: block.Start, Found: FOUND_PROLOGUE})
+		} else if i > 0 && followsPadding(g.sorted, i) {
+			add(Hint{Addr: block.Start, Found: FOUND_PADDING})
+		}
+	}
+
+	var entries []int
+	for addr := range found {
+		entries = append(entries, addr)
+	}
+	sort.Ints(entries)
+
+	var functions []*Function
+	for i, entry := range entries {
+		hint := found[entry]
+
+		name := hint.Name
+		if name == "" {
+			name = g.Blocks[entry].Instructions[0].Label
+		}
+		if name == "" || strings.HasPrefix(name, "offset_") {
+			name = fmt.Sprintf("sub_%08x", entry)
+		}
+
+		next := -1
+		if i+1 < len(entries) {
+			next = entries[i+1]
+		}
+
+		fn := g.function(name, entry, found)
+		fn.Found = hint.Found
+		fn.End = hint.End
+		if fn.End == 0 {
+			fn.End = entry
+			for _, block := range fn.Blocks {
+				if block.Start >= entry && (next < 0 || block.Start < next) && block.End > fn.End {
+					fn.End = block.End
+				}
+			}
+		}
+
+		functions = append(functions, fn)
+	}
+
+	return functions
+}
+
+// Is the block reached from other code, other than by falling through from
+// padding?
+func (g *CFG) isReached(block *Block) bool {
+	for _, edge := range block.Preds {
+		from := g.Blocks[edge.From]
+		if edge.Kind != EDGE_FALLTHROUGH || !IsPadding(from.Instructions[len(from.Instructions)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Does the block start by setting up a frame, "push ebp; mov ebp, esp", or
+// by making room on the stack, "sub esp, imm"?
+func HasPrologue(block *Block) bool {
+	first := block.Instructions[0].Literal
+
+	if len(first) >= 3 && first[0] == 0x83 && first[1] == 0xEC {
+		return true
+	}
+	if len(first) >= 6 && first[0] == 0x81 && first[1] == 0xEC {
+		return true
+	}
+
+	// Skip the "mov edi, edi" that hot-patchable functions start with.
+	i := 0
+	if len(first) == 2 && first[0] == 0x8B && first[1] == 0xFF {
+		i++
+	}
+	if i+1 >= len(block.Instructions) || len(block.Instructions[i].Literal) != 1 || block.Instructions[i].Literal[0] != 0x55 {
+		return false
+	}
+
+	next := block.Instructions[i+1].Literal
+	return len(next) == 2 && ((next[0] == 0x89 && next[1] == 0xE5) || (next[0] == 0x8B && next[1] == 0xEC))
+}
+
+// Does the block at index i come after padding, which comes after a return
+// or jump, or after a gap in the code?
+func followsPadding(blocks []*Block, i int) bool {
+	padded := false
+
+	for j := i - 1; j >= 0; j-- {
+		block := blocks[j]
+		if block.End != blocks[j+1].Start {
+			padded = true
+		}
+
+		for k := len(block.Instructions) - 1; k >= 0; k-- {
+			instruction := block.Instructions[k]
+			if IsPadding(instruction) {
+				padded = true
+				continue
+			}
+			return padded && (IsReturn(instruction) || IsJump(instruction))
+		}
+	}
+
+	return false
+}
+
+// Function entered at entry, made of the blocks reachable from there through
+// jumps and fallthroughs without entering any of the other entries.
+func (g *CFG) function(name string, entry int, entries map[int]*Hint) *Function {
+	fn := &Function{
+		Name:  name,
+		Entry: entry,
+	}
+
+	seen := make(map[int]bool)
+	pending := []int{entry}
+
+	for len(pending) > 0 {
+		offset := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		block, exists := g.Blocks[offset]
+		if !exists || seen[offset] {
+			continue
+		}
+		if _, other := entries[offset]; other && offset != entry {
+			continue
+		}
+		seen[offset] = true
+		fn.Blocks = append(fn.Blocks, block)
+
+		for _, edge := range block.Succs {
+			pending = append(pending, edge.To)
+		}
+	}
+
+	sort.Slice(fn.Blocks, func(i, j int) bool { return fn.Blocks[i].Start < fn.Blocks[j].Start })
+
+	return fn
+}
@@ -0,0 +1,275 @@
+package analysis
+
+import (
+	"disassembler/datatypes"
+	"sort"
+)
+
+// Every register
+const ALL_REGISTERS = datatypes.RegSet(0xFF)
+
+// Registers a call may change, those it may be passed arguments in, as
+// fastcall and thiscall do, and those live when a function returns: its
+// result, the stack and frame pointers, and the registers it must preserve,
+// as the usual 32-bit calling conventions have it.
+var (
+	CALLER_SAVED  = datatypes.RegSetOf(datatypes.REG_EAX, datatypes.REG_ECX, datatypes.REG_EDX)
+	ARGUMENT_REGS = datatypes.RegSetOf(datatypes.REG_ECX, datatypes.REG_EDX)
+	RETURN_LIVE   = datatypes.RegSetOf(datatypes.REG_EAX, datatypes.REG_EBX, datatypes.REG_ESP,
+		datatypes.REG_EBP, datatypes.REG_ESI, datatypes.REG_EDI)
+)
+
+// Registers live into and out of each block, by start, and before and after
+// each instruction, by offset. A register is live if its value may yet be
+// read before it's written.
+type Liveness struct {
+	In     map[int]datatypes.RegSet
+	Out    map[int]datatypes.RegSet
+	Before map[int]datatypes.RegSet
+	After  map[int]datatypes.RegSet
+}
+
+// Registers instruction reads and writes. Calls may read the argument
+// registers and change the caller-saved ones, returns hand the live
+// registers back to the caller, and anything the decoder doesn't understand
+// might read any.
+func uses(instruction *datatypes.Instruction) (datatypes.RegSet, datatypes.RegSet) {
+	s := instruction.Semantics
+	switch {
+	case s == nil:
+		return ALL_REGISTERS, 0
+	case IsCall(instruction):
+		return s.Reads | ARGUMENT_REGS, s.Writes | CALLER_SAVED
+	case IsReturn(instruction):
+		return s.Reads | RETURN_LIVE, s.Writes
+	}
+	return s.Reads, s.Writes
+}
+
+// Registers live on leaving block by its edges. Where control goes somewhere
+// unknown, as an indirect jump or into a byte the decoder doesn't know does,
+// everything is.
+func (g *CFG) liveOut(block *Block, in map[int]datatypes.RegSet) datatypes.RegSet {
+	last := block.Instructions[len(block.Instructions)-1]
+	if IsReturn(last) {
+		return 0
+	}
+
+	if len(block.Succs) == 0 {
+		return ALL_REGISTERS
+	}
+	if IsJump(last) && !last.HasTarget && len(last.Cases) == 0 {
+		return ALL_REGISTERS
+	}
+
+	var out datatypes.RegSet
+	for _, edge := range block.Succs {
+		if _, exists := g.Blocks[edge.To]; !exists {
+			return ALL_REGISTERS
+		}
+		out |= in[edge.To]
+	}
+	return out
+}
+
+// Work out which registers are live where, working back from each block's
+// successors until nothing changes.
+func (g *CFG) Liveness() *Liveness {
+	l := &Liveness{
+		In:     make(map[int]datatypes.RegSet),
+		Out:    make(map[int]datatypes.RegSet),
+		Before: make(map[int]datatypes.RegSet),
+		After:  make(map[int]datatypes.RegSet),
+	}
+
+	// Registers each block reads before writing, and writes.
+	use := make(map[int]datatypes.RegSet)
+	def := make(map[int]datatypes.RegSet)
+	for _, block := range g.sorted {
+		var u, d datatypes.RegSet
+		for _, instruction := range block.Instructions {
+			reads, writes := uses(instruction)
+			u |= reads &^ d
+			d |= writes
+		}
+		use[block.Start] = u
+		def[block.Start] = d
+	}
+
+	// Blocks later in the listing usually come later in the flow, so go
+	// backwards through them.
+	for changed := true; changed; {
+		changed = false
+		for i := len(g.sorted) - 1; i >= 0; i-- {
+			block := g.sorted[i]
+			out := g.liveOut(block, l.In)
+			in := use[block.Start] | (out &^ def[block.Start])
+			if out != l.Out[block.Start] || in != l.In[block.Start] {
+				l.Out[block.Start] = out
+				l.In[block.Start] = in
+				changed = true
+			}
+		}
+	}
+
+	for _, block := range g.sorted {
+		live := l.Out[block.Start]
+		for i := len(block.Instructions) - 1; i >= 0; i-- {
+			instruction := block.Instructions[i]
+			l.After[instruction.Offset] = live
+			reads, writes := uses(instruction)
+			live = reads | (live &^ writes)
+			l.Before[instruction.Offset] = live
+		}
+	}
+
+	return l
+}
+
+// Registers written by the instruction at offset that nothing reads after:
+// dead stores. The stack pointer doesn't count, and neither do the registers
+// a call changes.
+func (l *Liveness) Dead(instruction *datatypes.Instruction) datatypes.RegSet {
+	after, ok := l.After[instruction.Offset]
+	if !ok || instruction.Semantics == nil {
+		return 0
+	}
+	return instruction.Semantics.Writes &^ after &^ datatypes.RegSetOf(datatypes.REG_ESP)
+}
+
+// Definition of a register, at Def, and the instructions that read the
+// value it gives it.
+type Chain struct {
+	Def  int
+	Reg  datatypes.Register
+	Uses []int
+}
+
+// Definitions reaching a point, by register, as sorted offsets.
+type reaching [8][]int
+
+// Build the def-use chains of the graph, by offset of the definition: for
+// each register each instruction writes, the instructions that may read
+// what it wrote.
+func (g *CFG) DefUse() map[int][]*Chain {
+	in := make(map[int]*reaching)
+	out := make(map[int]*reaching)
+
+	transfer := func(block *Block, state reaching, visit func(*datatypes.Instruction, reaching)) reaching {
+		for _, instruction := range block.Instructions {
+			if visit != nil {
+				visit(instruction, state)
+			}
+			_, writes := uses(instruction)
+			for _, reg := range writes.Registers() {
+				state[reg] = []int{instruction.Offset}
+			}
+		}
+		return state
+	}
+
+	// Go forwards, merging what reaches each block from its predecessors,
+	// until nothing changes.
+	for changed := true; changed; {
+		changed = false
+		for _, block := range g.sorted {
+			var state reaching
+			for _, edge := range block.Preds {
+				if pred, exists := out[edge.From]; exists {
+					for reg := range state {
+						state[reg] = union(state[reg], pred[reg])
+					}
+				}
+			}
+			in[block.Start] = &state
+
+			after := transfer(block, state, nil)
+			if prev, exists := out[block.Start]; !exists || !same(*prev, after) {
+				out[block.Start] = &after
+				changed = true
+			}
+		}
+	}
+
+	chains := make(map[int][]*Chain)
+	chain := make(map[int]map[datatypes.Register]*Chain)
+	clobbered := make(map[*Chain]bool)
+	for _, block := range g.sorted {
+		for _, instruction := range block.Instructions {
+			_, writes := uses(instruction)
+			for _, reg := range writes.Registers() {
+				c := &Chain{Def: instruction.Offset, Reg: reg}
+				clobbered[c] = !instruction.Semantics.Writes.Has(reg)
+				chains[instruction.Offset] = append(chains[instruction.Offset], c)
+				if chain[instruction.Offset] == nil {
+					chain[instruction.Offset] = make(map[datatypes.Register]*Chain)
+				}
+				chain[instruction.Offset][reg] = c
+			}
+		}
+	}
+
+	for _, block := range g.sorted {
+		transfer(block, *in[block.Start], func(instruction *datatypes.Instruction, state reaching) {
+			reads, _ := uses(instruction)
+			if instruction.Semantics == nil {
+				return
+			}
+			for _, reg := range reads.Registers() {
+				for _, def := range state[reg] {
+					c := chain[def][reg]
+					c.Uses = append(c.Uses, instruction.Offset)
+				}
+			}
+		})
+	}
+
+	// A register a call only clobbers is only worth a chain if something
+	// reads it after, as it would the result in eax.
+	for offset, cs := range chains {
+		var kept []*Chain
+		for _, c := range cs {
+			if !clobbered[c] || len(c.Uses) > 0 {
+				kept = append(kept, c)
+			}
+		}
+		chains[offset] = kept
+	}
+
+	return chains
+}
+
+// Union of two sorted sets of offsets.
+func union(a, b []int) []int {
+	if len(b) == 0 {
+		return a
+	}
+	if len(a) == 0 {
+		return b
+	}
+
+	merged := append(append([]int(nil), a...), b...)
+	sort.Ints(merged)
+
+	var set []int
+	for i, offset := range merged {
+		if i == 0 || offset != merged[i-1] {
+			set = append(set, offset)
+		}
+	}
+	return set
+}
+
+func same(a, b reaching) bool {
+	for reg := range a {
+		if len(a[reg]) != len(b[reg]) {
+			return false
+		}
+		for i := range a[reg] {
+			if a[reg][i] != b[reg][i] {
+				return false
+			}
+		}
+	}
+	return true
+}
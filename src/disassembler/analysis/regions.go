@@ -0,0 +1,197 @@
+package analysis
+
+import (
+	"disassembler/datatypes"
+	"math"
+	"strings"
+)
+
+// Bytes scored at a time.
+const REGION_WINDOW = 256
+
+// Score from which a window is taken for code.
+const CODE_THRESHOLD = 0.5
+
+// Weights of the scores that make up a window's score
+const (
+	WEIGHT_VALID   = 0.35 // Decoding without unknown opcodes,
+	WEIGHT_COMMON  = 0.45 // using the opcodes compilers use most,
+	WEIGHT_ENTROPY = 0.2  // and having the entropy of code.
+)
+
+// The decoder doesn't know every opcode, so even code has some unknown ones.
+// Up to CODE_INVALID of them is as good as code gets, and from DATA_INVALID
+// as bad as data. Likewise, from COMMON_CODE common opcodes is as good as
+// code gets, and up to COMMON_DATA as bad as data.
+const (
+	CODE_INVALID = 0.3
+	DATA_INVALID = 0.7
+	CODE_COMMON  = 0.5
+	DATA_COMMON  = 0.1
+)
+
+// Opcodes that make up most compiled x86 code: moves, stack operations,
+// arithmetic, compares and branches.
+var CommonOpcodes = map[byte]bool{
+	0x01: true, 0x03: true, 0x29: true, 0x2B: true, 0x31: true,
+	0x33: true, 0x39: true, 0x3B: true, 0x74: true, 0x75: true, 0x83: true,
+	0x85: true, 0x89: true, 0x8B: true, 0x8D: true, 0xC3: true, 0xC7: true,
+	0xE8: true, 0xE9: true, 0xEB: true, 0xFF: true,
+}
+
+// Run of an image scored as code or data, with how sure the score is, from
+// 0 to 1, and what it's made of.
+type Region struct {
+	Start      int
+	End        int
+	Code       bool
+	Confidence float64
+	Invalid    float64 // Fraction of instructions that don't decode.
+	Common     float64 // Fraction of instructions with common opcodes.
+	Entropy    float64 // In bits a byte.
+}
+
+// Decoder of the instruction at the front of data, which is at offset. It
+// returns nil at the end of the data.
+type Decoder func(data []byte, offset int) *datatypes.Instruction
+
+// Score data, which is at offset, a window at a time as code or data, and
+// merge neighbouring windows scored alike into regions.
+func ClassifyRegions(data []byte, offset int, decode Decoder) []*Region {
+	var regions []*Region
+
+	for start := 0; start < len(data); start += REGION_WINDOW {
+		end := start + REGION_WINDOW
+		if end > len(data) {
+			end = len(data)
+		}
+		window := scoreWindow(data[start:], end-start, offset+start, decode)
+
+		last := len(regions) - 1
+		if last >= 0 && regions[last].Code == window.Code {
+			// Keep running averages, weighted by size.
+			region := regions[last]
+			a, b := float64(region.End-region.Start), float64(window.End-window.Start)
+			mean := func(x, y float64) float64 { return (x*a + y*b) / (a + b) }
+			region.Confidence = mean(region.Confidence, window.Confidence)
+			region.Invalid = mean(region.Invalid, window.Invalid)
+			region.Common = mean(region.Common, window.Common)
+			region.Entropy = mean(region.Entropy, window.Entropy)
+			region.End = window.End
+		} else {
+			regions = append(regions, window)
+		}
+	}
+
+	return regions
+}
+
+// Score the first size bytes of data, which is at offset. Instructions may
+// run past them into the rest of data.
+func scoreWindow(data []byte, size int, offset int, decode Decoder) *Region {
+	region := &Region{
+		Start:   offset,
+		End:     offset + size,
+		Entropy: Entropy(data[:size]),
+	}
+
+	// Padding is data, whatever it decodes as.
+	padding := true
+	for _, b := range data[:size] {
+		if b != 0x00 && b != 0x90 && b != 0xCC {
+			padding = false
+			break
+		}
+	}
+	if padding {
+		region.Confidence = 1
+		return region
+	}
+
+	// Padding between functions doesn't count either way.
+	var total, invalid, common int
+	for pos := 0; pos < size; {
+		instruction := decode(data[pos:], offset+pos)
+		if instruction == nil || len(instruction.Literal) == 0 {
+			invalid++
+			total++
+			break
+		}
+		pos += len(instruction.Literal)
+
+		if IsPadding(instruction) {
+			continue
+		}
+		total++
+		if instruction.Op == 0 && strings.HasPrefix(instruction.Mnemonic, "db ") {
+			invalid++
+		} else if CommonOpcodes[instruction.Op] {
+			common++
+		}
+	}
+	if total > 0 {
+		region.Invalid = float64(invalid) / float64(total)
+		region.Common = float64(common) / float64(total)
+	}
+
+	// A window can't have more bits a byte than the log of its size, so
+	// a short one's entropy is scaled up to compare.
+	entropy := region.Entropy
+	if size > 1 && size < REGION_WINDOW {
+		entropy *= math.Log2(REGION_WINDOW) / math.Log2(float64(size))
+	}
+
+	score := WEIGHT_VALID*clamp((DATA_INVALID-region.Invalid)/(DATA_INVALID-CODE_INVALID)) +
+		WEIGHT_COMMON*clamp((region.Common-DATA_COMMON)/(CODE_COMMON-DATA_COMMON)) +
+		WEIGHT_ENTROPY*entropyScore(entropy)
+
+	region.Code = score >= CODE_THRESHOLD
+	if region.Code {
+		region.Confidence = (score - CODE_THRESHOLD) / (1 - CODE_THRESHOLD)
+	} else {
+		region.Confidence = (CODE_THRESHOLD - score) / CODE_THRESHOLD
+	}
+
+	return region
+}
+
+// Shannon entropy of data, in bits a byte.
+func Entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	var entropy float64
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(len(data))
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// How much entropy looks like code's, from 0 to 1. Code is denser than text
+// and tables, but not as dense as compressed or encrypted data.
+func entropyScore(entropy float64) float64 {
+	switch {
+	case entropy < 3:
+		return 0
+	case entropy < 4.5:
+		return (entropy - 3) / 1.5
+	case entropy <= 7.2:
+		return 1
+	case entropy < 7.8:
+		return (7.8 - entropy) / 0.6
+	}
+	return 0
+}
+
+func clamp(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
@@ -0,0 +1,299 @@
+package analysis
+
+import (
+	"disassembler/datatypes"
+	"fmt"
+)
+
+// Stack frame of a function: whether it keeps ebp as a frame pointer, the
+// room it makes for locals, where the stack and frame pointers are before
+// each instruction it can tell, relative to the stack pointer on entry, and
+// the returns that leave the stack pointer elsewhere, by how far.
+type Frame struct {
+	Function     *Function
+	FramePointer bool
+	Locals       int
+	Esp          map[int]int
+	Ebp          map[int]int
+	Imbalances   map[int]int
+	Vars         map[int]*StackVar // Named memory operands, by instruction.
+}
+
+// Memory operand on the stack, addressed from Base, with where it reaches
+// relative to the stack pointer on entry, and the name of the argument or
+// local there: arg_N N bytes into the arguments, or var_N N bytes below the
+// frame pointer, or below the return address if there's none.
+type StackVar struct {
+	Base   datatypes.Register
+	Offset int
+	Name   string
+}
+
+// Where the stack and frame pointers are, if known.
+type stackState struct {
+	esp, ebp       int
+	esp_ok, ebp_ok bool
+}
+
+// Bytes the function's returns pop off the stack besides the return
+// address, as "retn 0x8" does.
+func (fn *Function) Pops() int {
+	for _, block := range fn.Blocks {
+		last := block.Instructions[len(block.Instructions)-1]
+		if last.Mnemonic == "retn" && len(last.Immediate) > 0 {
+			pops, _ := datatypes.BytesToInt(last.Immediate)
+			return pops
+		}
+	}
+	return 0
+}
+
+// Follow the stack and frame pointers through fn from its entry, until
+// nothing changes. pops gives the bytes each function called pops off the
+// stack on return, by entry; after calling any other, the stack pointer
+// isn't known. Where paths disagree, or an instruction changes a pointer
+// some other way, it's no longer known either.
+func AnalyzeStack(fn *Function, pops map[int]int) *Frame {
+	frame := &Frame{
+		Function:   fn,
+		Esp:        make(map[int]int),
+		Ebp:        make(map[int]int),
+		Imbalances: make(map[int]int),
+		Vars:       make(map[int]*StackVar),
+	}
+
+	blocks := make(map[int]*Block)
+	for _, block := range fn.Blocks {
+		blocks[block.Start] = block
+	}
+	if blocks[fn.Entry] == nil {
+		return frame
+	}
+
+	in := map[int]*stackState{fn.Entry: {esp_ok: true}}
+	pending := []int{fn.Entry}
+
+	for len(pending) > 0 {
+		block := blocks[pending[0]]
+		pending = pending[1:]
+
+		state := *in[block.Start]
+		for _, instruction := range block.Instructions {
+			frame.step(instruction, &state, pops)
+		}
+
+		for _, edge := range block.Succs {
+			if blocks[edge.To] == nil {
+				continue
+			}
+			if merged, changed := merge(in[edge.To], state); changed {
+				in[edge.To] = merged
+				pending = append(pending, edge.To)
+			}
+		}
+	}
+
+	// Now that the pointers are settled, name what's reached through them.
+	for _, block := range fn.Blocks {
+		for _, instruction := range block.Instructions {
+			if v := frame.stackVar(instruction); v != nil {
+				frame.Vars[instruction.Offset] = v
+			}
+		}
+	}
+
+	return frame
+}
+
+// Merge state into what's known at the start of a block, or nil if nothing
+// is yet. Returns whether that changed.
+func merge(known *stackState, state stackState) (*stackState, bool) {
+	if known == nil {
+		return &state, true
+	}
+
+	merged := *known
+	if merged.esp_ok && (!state.esp_ok || state.esp != merged.esp) {
+		merged.esp_ok = false
+	}
+	if merged.ebp_ok && (!state.ebp_ok || state.ebp != merged.ebp) {
+		merged.ebp_ok = false
+	}
+	return &merged, merged != *known
+}
+
+// Record the pointers before instruction, then move them past it.
+func (frame *Frame) step(instruction *datatypes.Instruction, state *stackState, pops map[int]int) {
+	if state.esp_ok {
+		frame.Esp[instruction.Offset] = state.esp
+	}
+	if state.ebp_ok {
+		frame.Ebp[instruction.Offset] = state.ebp
+	}
+
+	modrm := instruction.Modrm
+	direct := modrm != nil && modrm.Mod == datatypes.AM_DIRECT
+	imm, _ := datatypes.BytesToIntSigned(instruction.Immediate)
+
+	switch {
+	case instruction.Semantics == nil:
+		state.esp_ok, state.ebp_ok = false, false
+
+	case IsReturn(instruction):
+		if state.esp_ok && state.esp != 0 {
+			frame.Imbalances[instruction.Offset] = state.esp
+		}
+
+	case IsCall(instruction):
+		// The callee pops the return address, and its arguments if it
+		// cleans up after itself. If it's unknown, as an import called
+		// through its slot is, so is how much it pops.
+		if p, known := pops[instruction.Target]; instruction.HasTarget && known {
+			state.esp += p
+		} else {
+			state.esp_ok = false
+		}
+
+	case instruction.Mnemonic == "push":
+		state.esp -= 4
+
+	case instruction.Mnemonic == "pop":
+		state.esp += 4
+		if direct && modrm.RM == datatypes.REG_ESP || instruction.Op == 0x58+byte(datatypes.REG_ESP) {
+			state.esp_ok = false
+		}
+		if direct && modrm.RM == datatypes.REG_EBP || instruction.Op == 0x58+byte(datatypes.REG_EBP) {
+			state.ebp_ok = false
+		}
+
+	case (instruction.Mnemonic == "add" || instruction.Mnemonic == "sub") && direct &&
+		modrm.RM == datatypes.REG_ESP && len(instruction.Immediate) > 0:
+		if instruction.Mnemonic == "sub" {
+			imm = -imm
+			if -imm > frame.Locals {
+				frame.Locals = -imm
+			}
+		}
+		state.esp += imm
+
+	case instruction.Mnemonic == "mov" && direct && movesTo(instruction, datatypes.REG_EBP, datatypes.REG_ESP):
+		state.ebp, state.ebp_ok = state.esp, state.esp_ok
+		frame.FramePointer = frame.FramePointer || state.esp_ok
+
+	case instruction.Mnemonic == "mov" && direct && movesTo(instruction, datatypes.REG_ESP, datatypes.REG_EBP):
+		state.esp, state.esp_ok = state.ebp, state.ebp_ok
+
+	case instruction.Mnemonic == "lea" && modrm != nil && modrm.Reg == datatypes.REG_ESP:
+		switch base, disp, ok := frameOperand(instruction); {
+		case ok && base == datatypes.REG_EBP:
+			state.esp, state.esp_ok = state.ebp+disp, state.ebp_ok
+		case ok && base == datatypes.REG_ESP:
+			state.esp += disp
+		default:
+			state.esp_ok = false
+		}
+
+	case instruction.Mnemonic == "leave":
+		state.esp, state.esp_ok = state.ebp+4, state.ebp_ok
+		state.ebp_ok = false
+
+	case instruction.Mnemonic == "enter":
+		size, _ := datatypes.BytesToInt(instruction.Immediate[:2])
+		state.esp -= 4
+		state.ebp, state.ebp_ok = state.esp, state.esp_ok
+		state.esp -= size
+		frame.FramePointer = frame.FramePointer || state.esp_ok
+		if size > frame.Locals {
+			frame.Locals = size
+		}
+
+	default:
+		if instruction.Semantics.Writes.Has(datatypes.REG_ESP) {
+			state.esp_ok = false
+		}
+		if instruction.Semantics.Writes.Has(datatypes.REG_EBP) {
+			state.ebp_ok = false
+		}
+	}
+}
+
+// Does the mov instruction copy register from to register to?
+func movesTo(instruction *datatypes.Instruction, to, from datatypes.Register) bool {
+	modrm := instruction.Modrm
+	switch instruction.Op {
+	case 0x89:
+		return modrm.RM == to && modrm.Reg == from
+	case 0x8B:
+		return modrm.Reg == to && modrm.RM == from
+	}
+	return false
+}
+
+// Base register and signed displacement of a memory operand addressed from
+// a register alone, as [ ebp+0x00000008 ] or [ esp+0x00000010 ] are.
+func frameOperand(instruction *datatypes.Instruction) (datatypes.Register, int, bool) {
+	modrm := instruction.Modrm
+	if modrm == nil || modrm.Bits == datatypes.BITS_16 || modrm.Mod == datatypes.AM_DIRECT {
+		return 0, 0, false
+	}
+
+	base := modrm.RM
+	if modrm.Sib != nil {
+		if modrm.Sib.Index != datatypes.REG_ESP {
+			return 0, 0, false
+		}
+		base = modrm.Sib.Base
+	}
+	if modrm.Mod == datatypes.AM_REG && base == datatypes.REG_EBP {
+		return 0, 0, false
+	}
+
+	var disp int
+	if len(instruction.Displacement) > 0 {
+		disp, _ = datatypes.BytesToIntSigned(instruction.Displacement)
+	}
+	return base, disp, true
+}
+
+// Argument or local instruction's memory operand reaches, if it's addressed
+// from a known frame pointer, or from the stack pointer in a function without
+// one.
+func (frame *Frame) stackVar(instruction *datatypes.Instruction) *StackVar {
+	if instruction.Mnemonic == "lea" && instruction.Modrm.Reg == datatypes.REG_ESP {
+		return nil
+	}
+	base, disp, ok := frameOperand(instruction)
+	if !ok {
+		return nil
+	}
+
+	var at int
+	switch base {
+	case datatypes.REG_EBP:
+		ebp, known := frame.Ebp[instruction.Offset]
+		if !known {
+			return nil
+		}
+		at = ebp + disp
+		if at < 0 && disp < 0 {
+			return &StackVar{Base: base, Offset: at, Name: fmt.Sprintf("var_%X", -disp)}
+		}
+	case datatypes.REG_ESP:
+		esp, known := frame.Esp[instruction.Offset]
+		if !known || frame.FramePointer {
+			return nil
+		}
+		at = esp + disp
+		if at < 0 {
+			return &StackVar{Base: base, Offset: at, Name: fmt.Sprintf("var_%X", -at)}
+		}
+	default:
+		return nil
+	}
+
+	// The return address is at 0, and the arguments above it.
+	if at >= 4 {
+		return &StackVar{Base: base, Offset: at, Name: fmt.Sprintf("arg_%X", at-4)}
+	}
+	return nil
+}
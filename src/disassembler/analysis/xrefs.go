@@ -0,0 +1,114 @@
+package analysis
+
+import (
+	"disassembler/datatypes"
+	"sort"
+)
+
+// Kinds of cross-reference
+const (
+	XREF_JUMP   = "j" // Jump or conditional jump
+	XREF_CALL   = "c" // Call
+	XREF_DATA   = "d" // Absolute memory operand
+	XREF_OFFSET = "o" // Immediate that's an address
+)
+
+// Reference from the instruction at From to the address To.
+type Xref struct {
+	From int
+	To   int
+	Kind string
+}
+
+// Cross-reference index, by source and by target address, each in order.
+type Xrefs struct {
+	To   map[int][]*Xref
+	From map[int][]*Xref
+}
+
+// Index every reference made by the instructions: jumps and calls to their
+// targets, switches to their cases, and absolute memory operands to the
+// addresses they name, if mapped says the address is in the image. An
+// immediate is only taken for an address in the image if address says so
+// too, as a small constant can look like one.
+func BuildXrefs(instructions map[int]*datatypes.Instruction, mapped func(int) bool,
+	address func(*datatypes.Instruction, int) bool) *Xrefs {
+	x := &Xrefs{
+		To:   make(map[int][]*Xref),
+		From: make(map[int][]*Xref),
+	}
+
+	for offset, instruction := range instructions {
+		if !IsCode(instruction) {
+			continue
+		}
+
+		switch {
+		case instruction.HasTarget && IsCall(instruction):
+			x.add(offset, instruction.Target, XREF_CALL)
+		case instruction.HasTarget:
+			x.add(offset, instruction.Target, XREF_JUMP)
+		}
+
+		seen := make(map[int]bool)
+		for _, target := range instruction.Cases {
+			if !seen[target] {
+				seen[target] = true
+				x.add(offset, target, XREF_JUMP)
+			}
+		}
+
+		if addr, ok := MemoryAddress(instruction); ok && mapped(addr) {
+			x.add(offset, addr, XREF_DATA)
+		}
+
+		// Only immediates as wide as an address can be one.
+		size := len(instruction.Immediate)
+		if size == 4 || (size == 2 && instruction.Bits == datatypes.BITS_16) {
+			addr, err := datatypes.BytesToInt(instruction.Immediate)
+			if err == nil && addr != 0 && mapped(addr) && address(instruction, addr) {
+				x.add(offset, addr, XREF_OFFSET)
+			}
+		}
+	}
+
+	for _, refs := range x.To {
+		sort.Slice(refs, func(i, j int) bool { return refs[i].From < refs[j].From })
+	}
+	for _, refs := range x.From {
+		sort.Slice(refs, func(i, j int) bool { return refs[i].To < refs[j].To })
+	}
+
+	return x
+}
+
+func (x *Xrefs) add(from int, to int, kind string) {
+	xref := &Xref{from, to, kind}
+	x.To[to] = append(x.To[to], xref)
+	x.From[from] = append(x.From[from], xref)
+}
+
+// Address of an absolute memory operand, "[addr]", if the instruction has
+// one. Only that form has no base register: RM 5, or 6 in 16-bit code, or a
+// SIB with neither base nor index.
+func MemoryAddress(instruction *datatypes.Instruction) (int, bool) {
+	modrm := instruction.Modrm
+	if modrm == nil || modrm.Mod != datatypes.AM_REG || len(instruction.Displacement) == 0 {
+		return 0, false
+	}
+	switch {
+	case modrm.Bits == datatypes.BITS_16:
+		if modrm.RM != datatypes.REG_ESI {
+			return 0, false
+		}
+	case modrm.Sib != nil:
+		if modrm.Sib.Base != datatypes.REG_EBP || modrm.Sib.Index != datatypes.REG_ESP {
+			return 0, false
+		}
+	case modrm.RM != datatypes.REG_EBP:
+		return 0, false
+	}
+
+	addr, err := datatypes.BytesToInt(instruction.Displacement)
+	return addr, err == nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"strings"
 )
 
 // Instruction, with Displacement and Immediate stored as little-endian byte arrays.
@@ -20,7 +21,12 @@ type Instruction struct {
 	Immediate    []byte
 	DispSize     int
 	ImmSize      int
+	Bits         int
 	Operands     string
+	Target       int   // Branch or call target,
+	HasTarget    bool  // if the instruction has one.
+	Cases        []int // Targets of a switch's indirect jump, from its jump table.
+	Semantics    *Semantics
 }
 
 // Prefix Bytes
@@ -35,15 +41,17 @@ type ModRm struct {
 	Mod     AddressMode
 	Reg     Register
 	RM      Register
+	Bits    int
+	Sib     *SIB // Follows the MODRM if RM is esp and Mod isn't direct, in 32-bit code.
 }
 
 // SIB Byte
-// type SIB struct {
-// 	Literal byte
-// 	Scale   int
-// 	Index   Register
-// 	Base    Register
-// }
+type SIB struct {
+	Literal byte
+	Scale   int
+	Index   Register
+	Base    Register
+}
 
 // MODRM Address Modes
 type AddressMode byte
@@ -55,6 +63,12 @@ const (
 	AM_DIRECT       = AddressMode(byte(3))
 )
 
+// Operand and address sizes
+const (
+	BITS_16 = 16
+	BITS_32 = 32
+)
+
 // X86 Registers
 type Register int
 
@@ -70,6 +84,15 @@ const (
 )
 
 var Registers = make(map[Register]string)
+var Registers16 = make(map[Register]string)
+
+// How displacements from registers are written: in hex or decimal, and
+// zero-padded to their size or not. Addresses are always written in full.
+var DispDecimal = false
+var DispPad = false
+
+// 16-bit MODRM memory operands, by RM
+var Memory16 = []string{"bx+si", "bx+di", "bp+si", "bp+di", "si", "di", "bp", "bx"}
 
 func init() {
 	Registers[REG_EAX] = "eax"
@@ -80,6 +103,23 @@ func init() {
 	Registers[REG_EBP] = "ebp"
 	Registers[REG_ESI] = "esi"
 	Registers[REG_EDI] = "edi"
+
+	Registers16[REG_EAX] = "ax"
+	Registers16[REG_ECX] = "cx"
+	Registers16[REG_EDX] = "dx"
+	Registers16[REG_EBX] = "bx"
+	Registers16[REG_ESP] = "sp"
+	Registers16[REG_EBP] = "bp"
+	Registers16[REG_ESI] = "si"
+	Registers16[REG_EDI] = "di"
+}
+
+// Name of a register in code of the given operand size.
+func RegisterName(reg Register, bits int) string {
+	if bits == BITS_16 {
+		return Registers16[reg]
+	}
+	return Registers[reg]
 }
 
 func ParseModRM(modrm byte) *ModRm {
@@ -95,16 +135,35 @@ func ParseModRM(modrm byte) *ModRm {
 	}
 }
 
+func ParseSIB(sib byte) *SIB {
+	return &SIB{
+		Literal: sib,
+		Scale:   1 << (sib >> 6),
+		Index:   Register(int((sib >> 3) & 7)),
+		Base:    Register(int(sib & 7)),
+	}
+}
+
+// Does the MODRM have a SIB byte after it?
+func (modrm *ModRm) HasSIB() bool {
+	return modrm.Bits != BITS_16 && modrm.Mod != AM_DIRECT && modrm.RM == REG_ESP
+}
+
 func ParseDisplacement(modrm *ModRm, data *bytes.Buffer, size int) ([]byte, error) {
 	var err error
 	var displacement []byte
 	var disp byte
 
+	if modrm != nil && modrm.Bits == BITS_16 {
+		return ParseDisplacement16(modrm, data)
+	}
+
 	if modrm != nil {
 		switch modrm.Mod {
 
 		case AM_REG:
-			if modrm.RM == REG_EBP {
+			// A SIB with a base of ebp means no base, just a 32-bit displacement.
+			if modrm.RM == REG_EBP || (modrm.Sib != nil && modrm.Sib.Base == REG_EBP) {
 				if displacement = data.Next(4); len(displacement) != 4 {
 					return displacement, io.ErrUnexpectedEOF
 				} else {
@@ -143,6 +202,32 @@ func ParseDisplacement(modrm *ModRm, data *bytes.Buffer, size int) ([]byte, erro
 	}
 }
 
+// 16-bit addressing has no SIB, and its displacements are at most 2 bytes.
+func ParseDisplacement16(modrm *ModRm, data *bytes.Buffer) ([]byte, error) {
+	size := 0
+
+	switch modrm.Mod {
+	case AM_REG:
+		if modrm.RM == REG_ESI { // [disp16], in place of [bp]
+			size = 2
+		}
+	case AM_BYTE_OFFSET:
+		size = 1
+	case AM_DWORD_OFFSET:
+		size = 2
+	}
+
+	if size == 0 {
+		return nil, nil
+	}
+
+	if displacement := data.Next(size); len(displacement) != size {
+		return displacement, io.ErrUnexpectedEOF
+	} else {
+		return displacement, nil
+	}
+}
+
 func ParseImmediate(data *bytes.Buffer, size int) ([]byte, error) {
 	var err error
 	var immediate []byte
@@ -155,19 +240,32 @@ func ParseImmediate(data *bytes.Buffer, size int) ([]byte, error) {
 
 // Stringify the RM part of MODRM, depending on the Addressing Mode.
 func StringifyRM(modrm *ModRm, disp []byte) string {
+	if modrm != nil && modrm.Bits == BITS_16 {
+		return StringifyRM16(modrm, disp)
+	}
+
 	if modrm != nil {
 		rm := Registers[modrm.RM]
+		if modrm.Sib != nil {
+			rm = StringifySIB(modrm)
+		}
 
 		switch modrm.Mod {
 
 		case AM_REG:
+			if modrm.Sib != nil && modrm.Sib.Base == REG_EBP {
+				if rm == "" {
+					return fmt.Sprintf("[ %s ]", StringifyIntegerBytes(disp))
+				}
+				return fmt.Sprintf("[ %s+%s ]", rm, StringifyIntegerBytes(disp))
+			}
 			if modrm.RM == REG_EBP {
 				return fmt.Sprintf("[ %s ]", StringifyIntegerBytes(disp))
 			}
 			return fmt.Sprintf("[ %s ]", rm)
 
 		case AM_BYTE_OFFSET, AM_DWORD_OFFSET:
-			return fmt.Sprintf("[ %s+%s ]", rm, StringifyIntegerBytes(disp))
+			return fmt.Sprintf("[ %s%s ]", rm, StringifyDisplacement(disp))
 
 		case AM_DIRECT:
 			return rm
@@ -179,6 +277,50 @@ func StringifyRM(modrm *ModRm, disp []byte) string {
 	return ""
 }
 
+// Stringify the base and scaled index of a SIB, e.g. "ebx+esi*4". There's no
+// index if it's esp, and no base if it's ebp and Mod is 0.
+func StringifySIB(modrm *ModRm) string {
+	var parts []string
+	sib := modrm.Sib
+
+	if sib.Base != REG_EBP || modrm.Mod != AM_REG {
+		parts = append(parts, Registers[sib.Base])
+	}
+
+	if sib.Index != REG_ESP {
+		index := Registers[sib.Index]
+		if sib.Scale > 1 {
+			index += fmt.Sprintf("*%d", sib.Scale)
+		}
+		parts = append(parts, index)
+	}
+
+	return strings.Join(parts, "+")
+}
+
+// Stringify the RM part of a 16-bit MODRM, depending on the Addressing Mode.
+func StringifyRM16(modrm *ModRm, disp []byte) string {
+	mem := Memory16[modrm.RM]
+
+	switch modrm.Mod {
+
+	case AM_REG:
+		if modrm.RM == REG_ESI {
+			return fmt.Sprintf("[ %s ]", StringifyIntegerBytes(disp))
+		}
+		return fmt.Sprintf("[ %s ]", mem)
+
+	case AM_BYTE_OFFSET, AM_DWORD_OFFSET:
+		return fmt.Sprintf("[ %s%s ]", mem, StringifyDisplacement(disp))
+
+	case AM_DIRECT:
+		return Registers16[modrm.RM]
+
+	default:
+		return ""
+	}
+}
+
 // Convert a little-endian byte slice to the signed integer it represents.
 func BytesToIntSigned(intbytes []byte) (int, error) {
 	switch len(intbytes) {
@@ -193,6 +335,19 @@ func BytesToIntSigned(intbytes []byte) (int, error) {
 	}
 }
 
+// Sign-extend a little-endian integer to size bytes.
+func SignExtend(intbytes []byte, size int) []byte {
+	extended := append([]byte(nil), intbytes...)
+	fill := byte(0)
+	if len(intbytes) > 0 && intbytes[len(intbytes)-1]&0x80 != 0 {
+		fill = 0xFF
+	}
+	for len(extended) < size {
+		extended = append(extended, fill)
+	}
+	return extended
+}
+
 // Convert a little-endian byte slice to the integer it represents without two's complementing.
 func BytesToInt(intbytes []byte) (int, error) {
 	switch len(intbytes) {
@@ -212,6 +367,37 @@ func StringifyInteger(integer int) string {
 	return fmt.Sprintf("0x%08x", integer)
 }
 
+// Stringify a displacement from a register, sign-extended and with its sign,
+// e.g. "-0x8", as DispDecimal and DispPad say.
+func StringifyDisplacement(disp []byte) string {
+	var integer int
+	var err error
+
+	if integer, err = BytesToIntSigned(disp); err != nil {
+		return ""
+	}
+
+	sign := "+"
+	if integer < 0 {
+		sign = "-"
+		integer = -integer
+	}
+
+	// Pad to as many digits as the largest displacement of the size has.
+	width := 0
+	if DispPad {
+		width = 2 * len(disp)
+		if DispDecimal {
+			width = len(fmt.Sprintf("%d", 1<<(8*uint(len(disp))-1)))
+		}
+	}
+
+	if DispDecimal {
+		return fmt.Sprintf("%s%0*d", sign, width, integer)
+	}
+	return fmt.Sprintf("%s0x%0*x", sign, width, integer)
+}
+
 // Convert a little-endian byte slice representing an integer into a hex string.
 func StringifyIntegerBytes(intbytes []byte) string {
 	var integer int
@@ -0,0 +1,128 @@
+package datatypes
+
+import (
+	"strings"
+)
+
+// Set of general purpose registers, a bit for each.
+type RegSet uint8
+
+// Set of EFLAGS bits, as they are in the register.
+type Flags uint16
+
+// EFLAGS bits
+const (
+	FLAG_CF = Flags(1 << 0)
+	FLAG_PF = Flags(1 << 2)
+	FLAG_AF = Flags(1 << 4)
+	FLAG_ZF = Flags(1 << 6)
+	FLAG_SF = Flags(1 << 7)
+	FLAG_DF = Flags(1 << 10)
+	FLAG_OF = Flags(1 << 11)
+
+	FLAGS_OSZAPC = FLAG_OF | FLAG_SF | FLAG_ZF | FLAG_AF | FLAG_PF | FLAG_CF
+)
+
+// Registers, memory and flags an instruction reads and writes, whether named
+// by its operands or implied, as the stack pointer is by push.
+type Semantics struct {
+	Reads     RegSet
+	Writes    RegSet
+	MemRead   bool
+	MemWrite  bool
+	Tested    Flags // Flags read,
+	Set       Flags // written according to the result,
+	Cleared   Flags // cleared,
+	Undefined Flags // and left undefined.
+}
+
+// Set of the given registers.
+func RegSetOf(regs ...Register) RegSet {
+	var set RegSet
+	for _, reg := range regs {
+		set |= 1 << uint(reg)
+	}
+	return set
+}
+
+// Is reg in the set?
+func (set RegSet) Has(reg Register) bool {
+	return set&(1<<uint(reg)) != 0
+}
+
+// Registers in the set, in order.
+func (set RegSet) Registers() []Register {
+	var regs []Register
+	for reg := REG_EAX; reg <= REG_EDI; reg++ {
+		if set.Has(reg) {
+			regs = append(regs, reg)
+		}
+	}
+	return regs
+}
+
+// Names of the registers in the set, e.g. "eax, esi".
+func (set RegSet) String() string {
+	var names []string
+	for _, reg := range set.Registers() {
+		names = append(names, Registers[reg])
+	}
+	return strings.Join(names, ", ")
+}
+
+// Letters of the flags in the set, as in "OSZAPC".
+func (flags Flags) String() string {
+	letters := []struct {
+		flag   Flags
+		letter string
+	}{
+		{FLAG_OF, "O"}, {FLAG_DF, "D"}, {FLAG_SF, "S"}, {FLAG_ZF, "Z"},
+		{FLAG_AF, "A"}, {FLAG_PF, "P"}, {FLAG_CF, "C"},
+	}
+
+	var s string
+	for _, l := range letters {
+		if flags&l.flag != 0 {
+			s += l.letter
+		}
+	}
+	return s
+}
+
+// Registers the RM of a MODRM addresses memory through, if it isn't a
+// register itself.
+func (modrm *ModRm) AddressRegisters() RegSet {
+	if modrm.Mod == AM_DIRECT {
+		return 0
+	}
+
+	if modrm.Bits == BITS_16 {
+		if modrm.Mod == AM_REG && modrm.RM == REG_ESI {
+			return 0
+		}
+		return Memory16Registers[modrm.RM]
+	}
+
+	if modrm.Sib != nil {
+		var set RegSet
+		if modrm.Sib.Base != REG_EBP || modrm.Mod != AM_REG {
+			set |= RegSetOf(modrm.Sib.Base)
+		}
+		if modrm.Sib.Index != REG_ESP {
+			set |= RegSetOf(modrm.Sib.Index)
+		}
+		return set
+	}
+
+	if modrm.Mod == AM_REG && modrm.RM == REG_EBP {
+		return 0
+	}
+	return RegSetOf(modrm.RM)
+}
+
+// Registers of the 16-bit MODRM memory operands, by RM
+var Memory16Registers = []RegSet{
+	RegSetOf(REG_EBX, REG_ESI), RegSetOf(REG_EBX, REG_EDI),
+	RegSetOf(REG_EBP, REG_ESI), RegSetOf(REG_EBP, REG_EDI),
+	RegSetOf(REG_ESI), RegSetOf(REG_EDI), RegSetOf(REG_EBP), RegSetOf(REG_EBX),
+}
@@ -23,13 +23,15 @@ type NP struct{}
 type O struct{}
 type I struct{}
 type OI struct{}
+type II struct{}
 type D struct{}
 
 // ====================================================================================================================
 // 															Encoders
 // ====================================================================================================================
 
-// Consume the MODRM byte and the Displacement, depending on its Addressing Mode.
+// Consume the MODRM byte, the SIB byte if there is one, and the Displacement,
+// depending on its Addressing Mode.
 func (e M) Encode(data *bytes.Buffer, inst *datatypes.Instruction) error {
 	var err error
 	var next byte
@@ -39,14 +41,24 @@ func (e M) Encode(data *bytes.Buffer, inst *datatypes.Instruction) error {
 	}
 
 	inst.Modrm = datatypes.ParseModRM(next)
+	inst.Modrm.Bits = inst.Bits
 	inst.Literal = append(inst.Literal, inst.Modrm.Literal)
+
+	if inst.Modrm.HasSIB() {
+		if next, err = data.ReadByte(); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		inst.Modrm.Sib = datatypes.ParseSIB(next)
+		inst.Literal = append(inst.Literal, next)
+	}
+
 	inst.Displacement, err = datatypes.ParseDisplacement(inst.Modrm, data, 0)
 	inst.Literal = append(inst.Literal, inst.Displacement...)
 	return err
 }
 
 // Consume the MODRM byte and the Displacement, depending on its Addressing Mode,
-// and consume a 32-bit Immediate.
+// and consume a 32-bit Immediate (16-bit in 16-bit code).
 // Same as RMI.
 func (e MI) Encode(data *bytes.Buffer, inst *datatypes.Instruction) error {
 	var err error
@@ -55,7 +67,7 @@ func (e MI) Encode(data *bytes.Buffer, inst *datatypes.Instruction) error {
 		return err
 	}
 
-	inst.Immediate, err = datatypes.ParseImmediate(data, 4)
+	inst.Immediate, err = datatypes.ParseImmediate(data, inst.ImmSize)
 	inst.Literal = append(inst.Literal, inst.Immediate...)
 
 	return err
@@ -101,10 +113,18 @@ func (e I) Encode(data *bytes.Buffer, inst *datatypes.Instruction) error {
 	return err
 }
 
-// Register is encoded in the opcode itself. Consume a 32-bit Immediate.
+// Register is encoded in the opcode itself. Consume a 32-bit Immediate (16-bit in 16-bit code).
 func (e OI) Encode(data *bytes.Buffer, inst *datatypes.Instruction) error {
 	var err error
-	inst.Immediate, err = datatypes.ParseImmediate(data, 4)
+	inst.Immediate, err = datatypes.ParseImmediate(data, inst.ImmSize)
+	inst.Literal = append(inst.Literal, inst.Immediate...)
+	return err
+}
+
+// Consume a 16-bit Immediate and an 8-bit one after it, both kept in Immediate.
+func (e II) Encode(data *bytes.Buffer, inst *datatypes.Instruction) error {
+	var err error
+	inst.Immediate, err = datatypes.ParseImmediate(data, inst.ImmSize+1)
 	inst.Literal = append(inst.Literal, inst.Immediate...)
 	return err
 }
@@ -128,30 +148,34 @@ func (e M) StringifyOperands(inst *datatypes.Instruction) (string, int, bool, er
 }
 
 // Stringify the RM part of MODRM as first Operand, and Immediate as the second.
+// The 83 group's 8-bit Immediate is sign-extended to the operand size.
 func (e MI) StringifyOperands(inst *datatypes.Instruction) (string, int, bool, error) {
 	rm := datatypes.StringifyRM(inst.Modrm, inst.Displacement)
 	imm := datatypes.StringifyIntegerBytes(inst.Immediate)
+	if inst.Op == 0x83 && len(inst.Immediate) == 1 {
+		imm = datatypes.StringifyIntegerBytes(datatypes.SignExtend(inst.Immediate, inst.Bits/8))
+	}
 	return fmt.Sprintf("%s, %s", rm, imm), 0, false, nil
 }
 
 // Stringify the RM part of MODRM as the first Operand, and the Reg part as the second.
 func (e MR) StringifyOperands(inst *datatypes.Instruction) (string, int, bool, error) {
 	rm := datatypes.StringifyRM(inst.Modrm, inst.Displacement)
-	reg := datatypes.Registers[inst.Modrm.Reg]
+	reg := datatypes.RegisterName(inst.Modrm.Reg, inst.Bits)
 	return fmt.Sprintf("%s, %s", rm, reg), 0, false, nil
 }
 
 // Stringify the Reg part of MODRM as the first Operand, and the RM part as the second.
 func (e RM) StringifyOperands(inst *datatypes.Instruction) (string, int, bool, error) {
 	rm := datatypes.StringifyRM(inst.Modrm, inst.Displacement)
-	reg := datatypes.Registers[inst.Modrm.Reg]
+	reg := datatypes.RegisterName(inst.Modrm.Reg, inst.Bits)
 	return fmt.Sprintf("%s, %s", reg, rm), 0, false, nil
 }
 
 // Stringify the Reg part of MODRM as the first Operand, the RM part as the second, and Immediate as the third.
 func (e RMI) StringifyOperands(inst *datatypes.Instruction) (string, int, bool, error) {
 	rm := datatypes.StringifyRM(inst.Modrm, inst.Displacement)
-	reg := datatypes.Registers[inst.Modrm.Reg]
+	reg := datatypes.RegisterName(inst.Modrm.Reg, inst.Bits)
 	imm := datatypes.StringifyIntegerBytes(inst.Immediate)
 	return fmt.Sprintf("%s, %s, %s", reg, rm, imm), 0, false, nil
 }
@@ -164,7 +188,7 @@ func (e NP) StringifyOperands(inst *datatypes.Instruction) (string, int, bool, e
 // Stringify Register as Operand from last 3 bits of Opcode.
 func (e O) StringifyOperands(inst *datatypes.Instruction) (string, int, bool, error) {
 	reg := datatypes.Register(int(inst.Op & 7))
-	return datatypes.Registers[reg], 0, false, nil
+	return datatypes.RegisterName(reg, inst.Bits), 0, false, nil
 }
 
 // Stringify Immediate as the Operand.
@@ -175,11 +199,19 @@ func (e I) StringifyOperands(inst *datatypes.Instruction) (string, int, bool, er
 
 //  Stringify Register as first Operand from last 3 bits of Opcode, and Immediate as the second Operand.
 func (e OI) StringifyOperands(inst *datatypes.Instruction) (string, int, bool, error) {
-	reg := datatypes.Registers[datatypes.Register(int(inst.Op&7))]
+	reg := datatypes.RegisterName(datatypes.Register(int(inst.Op&7)), inst.Bits)
 	imm := datatypes.StringifyIntegerBytes(inst.Immediate)
 	return fmt.Sprintf("%s, %s", reg, imm), 0, false, nil
 }
 
+// Stringify the 16-bit Immediate as the first Operand, and the 8-bit one as the second.
+func (e II) StringifyOperands(inst *datatypes.Instruction) (string, int, bool, error) {
+	size := len(inst.Immediate) - 1
+	first := datatypes.StringifyIntegerBytes(inst.Immediate[:size])
+	second := datatypes.StringifyIntegerBytes(inst.Immediate[size:])
+	return fmt.Sprintf("%s, %s", first, second), 0, false, nil
+}
+
 // Stringify Displacement into a Label as an offset from current instruction.
 func (e D) StringifyOperands(inst *datatypes.Instruction) (string, int, bool, error) {
 	start := inst.Offset + len(inst.Literal)
@@ -231,3 +263,7 @@ func (e OI) Encoding() string {
 func (e D) Encoding() string {
 	return "D"
 }
+
+func (e II) Encoding() string {
+	return "II"
+}
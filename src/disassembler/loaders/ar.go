@@ -0,0 +1,109 @@
+package loaders
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Archive magic and header size
+const (
+	AR_MAGIC       = "!<arch>\n"
+	AR_HEADER_SIZE = 60
+)
+
+// Load the object files in a static library. Each member is laid out after
+// the last, and its sections are named "member.o:.text" and so on. Members
+// that aren't objects, such as symbol tables and import stubs, are skipped.
+func LoadArchive(data []byte) (*Image, error) {
+	var longnames []byte
+	var end int
+
+	img := &Image{
+		Format:      "ar",
+		Arch:        ARCH_X86,
+		Relocations: make(map[int]*Relocation),
+	}
+
+	pos := len(AR_MAGIC)
+	for pos+AR_HEADER_SIZE <= len(data) {
+		header := data[pos : pos+AR_HEADER_SIZE]
+		if string(header[58:60]) != "`\n" {
+			return nil, fmt.Errorf("Bad archive member header at 0x%x.", pos)
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(string(header[48:58])))
+		if err != nil || pos+AR_HEADER_SIZE+size > len(data) {
+			return nil, fmt.Errorf("Bad archive member size at 0x%x.", pos)
+		}
+
+		body := data[pos+AR_HEADER_SIZE : pos+AR_HEADER_SIZE+size]
+		name := strings.TrimRight(string(header[:16]), " ")
+
+		// Members are 2-byte aligned.
+		pos += AR_HEADER_SIZE + size + size%2
+
+		switch {
+
+		case name == "//":
+			// GNU and COFF long name table.
+			longnames = body
+			continue
+
+		case strings.HasPrefix(name, "#1/"):
+			// BSD long name, stored at the start of the body.
+			n, err := strconv.Atoi(name[3:])
+			if err != nil || n > len(body) {
+				return nil, fmt.Errorf("Bad BSD archive member name: %s", name)
+			}
+			name = string(bytes.TrimRight(body[:n], "\x00"))
+			body = body[n:]
+
+		case len(name) > 1 && name[0] == '/' && name[1] >= '0' && name[1] <= '9':
+			// Offset into the long name table.
+			n, err := strconv.Atoi(name[1:])
+			if err != nil || n > len(longnames) {
+				return nil, fmt.Errorf("Bad archive long name reference: %s", name)
+			}
+			name = string(longnames[n:])
+			if i := strings.IndexAny(name, "/\n\x00"); i >= 0 {
+				name = name[:i]
+			}
+
+		default:
+			name = strings.TrimSuffix(name, "/")
+		}
+
+		// Symbol tables: "/" and "/SYM64/" for GNU and COFF, "__.SYMDEF" for BSD.
+		if name == "" || name == "/SYM64" || strings.HasPrefix(name, "__.SYMDEF") {
+			continue
+		}
+
+		prefix := name + ":"
+
+		switch {
+
+		case len(body) >= 4 && string(body[:4]) == ELF_MAGIC:
+			f, err := elf.NewFile(bytes.NewReader(body))
+			if err != nil {
+				return nil, fmt.Errorf("Error reading member %s: %s", name, err)
+			}
+			if f.Type != elf.ET_REL {
+				continue
+			}
+			img.Arch = elfArch(f.Machine)
+			if end, err = loadELFObject(f, img, prefix, end); err != nil {
+				return nil, fmt.Errorf("Error loading member %s: %s", name, err)
+			}
+
+		case IsCOFF(body):
+			if end, err = loadCOFFObject(body, img, prefix, end); err != nil {
+				return nil, fmt.Errorf("Error loading member %s: %s", name, err)
+			}
+		}
+	}
+
+	return img, nil
+}
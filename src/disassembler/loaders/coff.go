@@ -0,0 +1,225 @@
+package loaders
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+)
+
+// COFF section flags missing from debug/pe
+const (
+	IMAGE_SCN_LNK_INFO   = 0x00000200
+	IMAGE_SCN_LNK_REMOVE = 0x00000800
+)
+
+// COFF symbol storage classes and types
+const (
+	IMAGE_SYM_CLASS_EXTERNAL = 2
+	IMAGE_SYM_CLASS_STATIC   = 3
+	IMAGE_SYM_DTYPE_FUNCTION = 0x20
+)
+
+// COFF relocation types
+const (
+	IMAGE_REL_I386_DIR32   = 0x06
+	IMAGE_REL_I386_DIR32NB = 0x07
+	IMAGE_REL_I386_REL32   = 0x14
+)
+
+// Does data look like a COFF object? There's no magic number, so check the
+// machine and that there's no optional header, which only images have.
+func IsCOFF(data []byte) bool {
+	if len(data) < 20 {
+		return false
+	}
+
+	machine := binary.LittleEndian.Uint16(data)
+	sections := binary.LittleEndian.Uint16(data[2:])
+	optional := binary.LittleEndian.Uint16(data[16:])
+
+	return (machine == pe.IMAGE_FILE_MACHINE_I386 || machine == pe.IMAGE_FILE_MACHINE_AMD64) &&
+		sections > 0 && sections <= 96 && optional == 0
+}
+
+// Load a COFF object file.
+func LoadCOFF(data []byte) (*Image, error) {
+	img := &Image{
+		Format: "coff",
+	}
+	_, err := loadCOFFObject(data, img, "", 0)
+	return img, err
+}
+
+// Lay out the sections of a COFF object from base onwards and apply its
+// relocations, as for ELF objects. Returns the end of the layout.
+func loadCOFFObject(data []byte, img *Image, prefix string, base int) (int, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return base, err
+	}
+	defer f.Close()
+
+	switch f.Machine {
+	case pe.IMAGE_FILE_MACHINE_I386:
+		img.Arch = ARCH_X86
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		img.Arch = ARCH_X86_64
+	default:
+		return base, fmt.Errorf("Unsupported COFF machine: 0x%04x", f.Machine)
+	}
+
+	if img.Relocations == nil {
+		img.Relocations = make(map[int]*Relocation)
+	}
+
+	addrs := make(map[int]int)
+	sections := make(map[int]*Section)
+	end := base
+
+	for i, s := range f.Sections {
+		// Linker directives and the like aren't part of the program.
+		if s.Characteristics&(IMAGE_SCN_LNK_INFO|IMAGE_SCN_LNK_REMOVE) != 0 {
+			continue
+		}
+
+		// Objects give the size of .bss in SizeOfRawData, and leave
+		// VirtualSize 0, but take whichever is larger in case they don't.
+		size := int(s.Size)
+		if int(s.VirtualSize) > size {
+			size = int(s.VirtualSize)
+		}
+
+		// Section numbers in symbols are 1-based.
+		end = layout(end, coffAlign(s.Characteristics))
+		addrs[i+1] = end
+		end += size
+
+		if s.Characteristics&pe.IMAGE_SCN_CNT_UNINITIALIZED_DATA != 0 {
+			continue
+		}
+
+		contents, err := s.Data()
+		if err != nil {
+			return end, fmt.Errorf("Error reading section %s: %s", s.Name, err)
+		}
+
+		sec := &Section{
+			Name: prefix + s.Name,
+			Addr: addrs[i+1],
+			Data: contents,
+			Exec: s.Characteristics&(pe.IMAGE_SCN_CNT_CODE|pe.IMAGE_SCN_MEM_EXECUTE) != 0,
+		}
+		sections[i+1] = sec
+		img.Sections = append(img.Sections, sec)
+	}
+
+	for i, s := range f.Sections {
+		target, ok := sections[i+1]
+		if !ok {
+			continue
+		}
+
+		for _, reloc := range s.Relocs {
+			r := &Relocation{
+				Addr: target.Addr + int(reloc.VirtualAddress),
+				Size: 4,
+			}
+
+			// Types that aren't listed, such as ABSOLUTE, SECTION and SECREL,
+			// are skipped rather than taken for an address and patched in.
+			// AMD64 objects are only loaded to be rejected, so theirs are too.
+			switch {
+			case f.Machine == pe.IMAGE_FILE_MACHINE_I386 && reloc.Type == IMAGE_REL_I386_DIR32:
+			case f.Machine == pe.IMAGE_FILE_MACHINE_I386 && reloc.Type == IMAGE_REL_I386_REL32:
+				r.PCRel = true
+			default:
+				continue
+			}
+
+			field := int(reloc.VirtualAddress)
+			if field+r.Size > len(target.Data) {
+				return end, fmt.Errorf("Relocation at 0x%x is outside section %s.", field, target.Name)
+			}
+
+			// COFF keeps the addend in the field being relocated.
+			r.Addend = int(int32(binary.LittleEndian.Uint32(target.Data[field:])))
+
+			if int(reloc.SymbolTableIndex) < len(f.COFFSymbols) {
+				sym := &f.COFFSymbols[reloc.SymbolTableIndex]
+				if r.Symbol, err = coffSymbolName(sym, f.StringTable); err != nil {
+					return end, err
+				}
+				if addr, ok := addrs[int(sym.SectionNumber)]; ok {
+					r.Value = addr + int(sym.Value)
+					r.Defined = true
+				}
+			}
+
+			r.Apply(target.Data, target.Addr)
+			img.Relocations[r.Addr] = r
+		}
+	}
+
+	syms, err := coffSymbols(f, addrs)
+	if err != nil {
+		return end, err
+	}
+	img.AddSymbols(syms)
+
+	return end, nil
+}
+
+// Symbols from a COFF symbol table, where addrs gives the address of each
+// section by its 1-based section number.
+func coffSymbols(f *pe.File, addrs map[int]int) ([]*Symbol, error) {
+	var syms []*Symbol
+
+	for i := 0; i < len(f.COFFSymbols); i++ {
+		sym := &f.COFFSymbols[i]
+
+		// Auxiliary records follow the symbol they belong to.
+		aux := int(sym.NumberOfAuxSymbols)
+		i += aux
+
+		base, ok := addrs[int(sym.SectionNumber)]
+		if !ok || (sym.StorageClass != IMAGE_SYM_CLASS_EXTERNAL && sym.StorageClass != IMAGE_SYM_CLASS_STATIC) {
+			continue
+		}
+
+		// Section definitions are static symbols with an aux record.
+		if sym.StorageClass == IMAGE_SYM_CLASS_STATIC && sym.Value == 0 && aux > 0 {
+			continue
+		}
+
+		name, err := coffSymbolName(sym, f.StringTable)
+		if err != nil {
+			return nil, err
+		}
+
+		syms = append(syms, &Symbol{
+			Name: name,
+			Addr: base + int(sym.Value),
+			Func: sym.Type&0xF0 == IMAGE_SYM_DTYPE_FUNCTION,
+		})
+	}
+
+	return syms, nil
+}
+
+// Name of a COFF symbol, either inline or, if the first four bytes of the
+// name are zero, at an offset into the string table.
+func coffSymbolName(sym *pe.COFFSymbol, table pe.StringTable) (string, error) {
+	if binary.LittleEndian.Uint32(sym.Name[:4]) == 0 {
+		return table.String(binary.LittleEndian.Uint32(sym.Name[4:]))
+	}
+	return string(bytes.TrimRight(sym.Name[:], "\x00")), nil
+}
+
+// Alignment of a COFF section, from its IMAGE_SCN_ALIGN_* flags.
+func coffAlign(characteristics uint32) int {
+	if n := (characteristics >> 20) & 0xF; n > 0 {
+		return 1 << (n - 1)
+	}
+	return 1
+}
@@ -0,0 +1,94 @@
+package loaders
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"sort"
+)
+
+// Source line that the code at Addr was compiled from.
+type Line struct {
+	Addr int
+	File string
+	Line int
+}
+
+// Read the line table from an ELF file's .debug_line, and name any functions
+// in .debug_info that the symbol table doesn't. Files without DWARF are left
+// alone, as are broken line tables, which shouldn't stop the disassembly.
+func (img *Image) elfDWARF(f *elf.File) {
+	d, err := f.DWARF()
+	if err != nil {
+		return
+	}
+
+	var syms []*Symbol
+	lines := make(map[int]*Line)
+
+	r := d.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil || entry == nil {
+			break
+		}
+
+		switch entry.Tag {
+
+		case dwarf.TagCompileUnit:
+			lr, err := d.LineReader(entry)
+			if err != nil || lr == nil {
+				continue
+			}
+
+			var le dwarf.LineEntry
+			for {
+				if err := lr.Next(&le); err != nil {
+					break
+				}
+				if le.EndSequence || le.Line == 0 || le.File == nil {
+					continue
+				}
+
+				// Of several rows for an address, the last is the one
+				// the code belongs to.
+				lines[int(le.Address)] = &Line{
+					Addr: int(le.Address),
+					File: le.File.Name,
+					Line: le.Line,
+				}
+			}
+
+		case dwarf.TagSubprogram:
+			name, _ := entry.Val(dwarf.AttrName).(string)
+			low, ok := entry.Val(dwarf.AttrLowpc).(uint64)
+			if name == "" || !ok {
+				continue
+			}
+			if sym, offset := img.SymbolAt(int(low)); sym != nil && offset == 0 {
+				continue
+			}
+
+			sym := &Symbol{
+				Name: name,
+				Addr: int(low),
+				Func: true,
+			}
+
+			// DWARF 4 and later give the high PC as a size.
+			switch high := entry.Val(dwarf.AttrHighpc).(type) {
+			case int64:
+				sym.Size = int(high)
+			case uint64:
+				sym.Size = int(high - low)
+			}
+			syms = append(syms, sym)
+		}
+	}
+
+	img.AddSymbols(syms)
+
+	for _, line := range lines {
+		img.Lines = append(img.Lines, line)
+	}
+	sort.Slice(img.Lines, func(i, j int) bool { return img.Lines[i].Addr < img.Lines[j].Addr })
+}
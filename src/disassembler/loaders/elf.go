@@ -0,0 +1,485 @@
+package loaders
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+)
+
+// ELF magic and core note types
+const (
+	ELF_MAGIC = "\x7fELF"
+
+	NT_PRSTATUS = 1
+)
+
+// Size of a PLT entry, on both i386 and x86-64
+const PLT_ENTRY_SIZE = 16
+
+// Offsets of the registers in the elf_prstatus note of a core file.
+const (
+	PRSTATUS_PID_32 = 24
+	PRSTATUS_EIP_32 = 72 + 12*4 // pr_reg.eip
+	PRSTATUS_ESP_32 = 72 + 15*4 // pr_reg.esp
+
+	PRSTATUS_PID_64 = 32
+	PRSTATUS_RIP_64 = 112 + 16*8 // pr_reg.rip
+	PRSTATUS_RSP_64 = 112 + 19*8 // pr_reg.rsp
+)
+
+// Load an ELF executable, shared object or core file.
+func LoadELF(data []byte) (*Image, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img := &Image{
+		Format: "elf",
+		Arch:   elfArch(f.Machine),
+		Entry:  int(f.Entry),
+	}
+	if img.Arch == "" {
+		return nil, fmt.Errorf("Unsupported ELF machine: %s", f.Machine)
+	}
+
+	switch f.Type {
+	case elf.ET_CORE:
+		return img, loadCore(f, img)
+	case elf.ET_REL:
+		img.Format = "elf-rel"
+		img.Entry = 0
+		_, err = loadELFObject(f, img, "", 0)
+		return img, err
+	}
+
+	for _, s := range f.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 || s.Type == elf.SHT_NOBITS {
+			continue
+		}
+
+		var contents []byte
+		if contents, err = s.Data(); err != nil {
+			return nil, fmt.Errorf("Error reading section %s: %s", s.Name, err)
+		}
+
+		img.Sections = append(img.Sections, &Section{
+			Name: s.Name,
+			Addr: int(s.Addr),
+			Data: contents,
+			Exec: s.Flags&elf.SHF_EXECINSTR != 0,
+		})
+	}
+
+	img.AddSymbols(elfSymbols(f, nil))
+
+	plt, err := elfPLT(f)
+	if err != nil {
+		return nil, err
+	}
+	img.AddSymbols(plt)
+
+	img.elfDWARF(f)
+	img.elfEHFrame(f)
+
+	return img, nil
+}
+
+// Symbols for the PLT stubs and GOT slots of imported functions, named
+// "puts@plt" and "puts@got", from the JUMP_SLOT relocations in .rel.plt or
+// .rela.plt. The linker lays out the stubs in the same order as the
+// relocations: in .plt after the resolver stub PLT0, or in .plt.sec when
+// the binary is built for CET.
+func elfPLT(f *elf.File) ([]*Symbol, error) {
+	var syms []*Symbol
+
+	rel := f.Section(".rela.plt")
+	if rel == nil {
+		rel = f.Section(".rel.plt")
+	}
+	if rel == nil {
+		return nil, nil
+	}
+
+	contents, err := rel.Data()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading section %s: %s", rel.Name, err)
+	}
+
+	dynsym, err := f.DynamicSymbols()
+	if err != nil {
+		return nil, nil
+	}
+
+	stubs := 0
+	plt := f.Section(".plt.sec")
+	if plt == nil {
+		plt = f.Section(".plt")
+		stubs = PLT_ENTRY_SIZE
+	}
+
+	size := 8
+	if f.Class == elf.ELFCLASS64 {
+		size = 16
+	}
+	if rel.Type == elf.SHT_RELA {
+		size += size / 2
+	}
+
+	for i := 0; i+size <= len(contents); i += size {
+		var slot, sym int
+
+		if f.Class == elf.ELFCLASS64 {
+			slot = int(f.ByteOrder.Uint64(contents[i:]))
+			sym = int(f.ByteOrder.Uint64(contents[i+8:]) >> 32)
+		} else {
+			slot = int(f.ByteOrder.Uint32(contents[i:]))
+			sym = int(f.ByteOrder.Uint32(contents[i+4:]) >> 8)
+		}
+
+		if sym == 0 || sym > len(dynsym) {
+			continue
+		}
+		name := dynsym[sym-1].Name
+
+		syms = append(syms, &Symbol{
+			Name:   name + "@got",
+			Addr:   slot,
+			Size:   size / 2,
+			Import: true,
+		})
+
+		if plt != nil {
+			syms = append(syms, &Symbol{
+				Name:   name + "@plt",
+				Addr:   int(plt.Addr) + stubs,
+				Size:   PLT_ENTRY_SIZE,
+				Func:   true,
+				Import: true,
+			})
+			stubs += PLT_ENTRY_SIZE
+		}
+	}
+
+	return syms, nil
+}
+
+// Symbols of an ELF file from .symtab and .dynsym. For relocatable objects,
+// addrs gives the address each section was laid out at, by section index.
+func elfSymbols(f *elf.File, addrs map[int]int) []*Symbol {
+	var syms []*Symbol
+
+	// Either table may be missing, e.g. from stripped or static binaries.
+	symtab, _ := f.Symbols()
+	dynsym, _ := f.DynamicSymbols()
+
+	for _, s := range append(symtab, dynsym...) {
+		kind := elf.ST_TYPE(s.Info)
+		if s.Name == "" || s.Section == elf.SHN_UNDEF || s.Section >= elf.SHN_LORESERVE ||
+			(kind != elf.STT_FUNC && kind != elf.STT_OBJECT && kind != elf.STT_NOTYPE && kind != elf.STT_GNU_IFUNC) {
+			continue
+		}
+
+		addr := int(s.Value)
+		if addrs != nil {
+			base, ok := addrs[int(s.Section)]
+			if !ok {
+				continue
+			}
+			addr += base
+		}
+
+		syms = append(syms, &Symbol{
+			Name: s.Name,
+			Addr: addr,
+			Size: int(s.Size),
+			Func: kind == elf.STT_FUNC || kind == elf.STT_GNU_IFUNC,
+		})
+	}
+
+	return syms
+}
+
+// Lay out the sections of a relocatable object from base onwards, since they
+// all claim address 0, then apply its relocations. Section names are given
+// prefix, to tell archive members apart. Returns the end of the layout.
+func loadELFObject(f *elf.File, img *Image, prefix string, base int) (int, error) {
+	if img.Relocations == nil {
+		img.Relocations = make(map[int]*Relocation)
+	}
+
+	addrs := make(map[int]int)
+	sections := make(map[int]*Section)
+	end := base
+
+	for i, s := range f.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 {
+			continue
+		}
+
+		end = layout(end, int(s.Addralign))
+		addrs[i] = end
+		end += int(s.Size)
+
+		if s.Type == elf.SHT_NOBITS {
+			continue
+		}
+
+		contents, err := s.Data()
+		if err != nil {
+			return end, fmt.Errorf("Error reading section %s: %s", s.Name, err)
+		}
+
+		sec := &Section{
+			Name: prefix + s.Name,
+			Addr: addrs[i],
+			Data: contents,
+			Exec: s.Flags&elf.SHF_EXECINSTR != 0,
+		}
+		sections[i] = sec
+		img.Sections = append(img.Sections, sec)
+	}
+
+	symbols, err := f.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return end, err
+	}
+
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_REL && s.Type != elf.SHT_RELA {
+			continue
+		}
+
+		target, ok := sections[int(s.Info)]
+		if !ok {
+			continue
+		}
+
+		contents, err := s.Data()
+		if err != nil {
+			return end, fmt.Errorf("Error reading section %s: %s", s.Name, err)
+		}
+
+		relocs, err := parseELFRelocations(f, s.Type == elf.SHT_RELA, contents, target)
+		if err != nil {
+			return end, err
+		}
+
+		for _, r := range relocs {
+			if r.sym > 0 && r.sym <= len(symbols) {
+				sym := symbols[r.sym-1]
+				r.Symbol = sym.Name
+
+				if elf.ST_TYPE(sym.Info) == elf.STT_SECTION && int(sym.Section) < len(f.Sections) {
+					r.Symbol = f.Sections[sym.Section].Name
+				}
+				if addr, ok := addrs[int(sym.Section)]; ok {
+					r.Value = addr + int(sym.Value)
+					r.Defined = true
+				}
+			}
+
+			r.Apply(target.Data, target.Addr)
+			img.Relocations[r.Addr] = r.Relocation
+		}
+	}
+
+	img.AddSymbols(elfSymbols(f, addrs))
+
+	return end, nil
+}
+
+// Relocation along with the index of its symbol, still to be resolved.
+type elfRelocation struct {
+	*Relocation
+	sym int
+}
+
+// Parse a SHT_REL or SHT_RELA section applying to target. REL entries keep
+// their addend in the field being relocated. Only i386 relocations are read;
+// x86-64 objects are only loaded to be rejected.
+func parseELFRelocations(f *elf.File, rela bool, contents []byte, target *Section) ([]elfRelocation, error) {
+	var relocs []elfRelocation
+	order := f.ByteOrder
+
+	if f.Machine != elf.EM_386 {
+		return nil, nil
+	}
+
+	size := 8
+	if rela {
+		size = 12
+	}
+
+	for i := 0; i+size <= len(contents); i += size {
+		entry := contents[i : i+size]
+		var addend int
+
+		info := order.Uint32(entry[4:])
+		offset := int(order.Uint32(entry))
+		sym, kind := int(info>>8), int(info&0xFF)
+		if rela {
+			addend = int(int32(order.Uint32(entry[8:])))
+		}
+
+		r := &Relocation{
+			Addr: target.Addr + offset,
+			Size: 4,
+		}
+		var gotpc bool
+
+		// Types that aren't listed, such as the TLS ones, are skipped rather
+		// than taken for an address and patched in.
+		switch elf.R_386(kind) {
+		case elf.R_386_32:
+		case elf.R_386_PC32, elf.R_386_PLT32:
+			r.PCRel = true
+		case elf.R_386_GOT32, elf.R_386_GOT32X:
+			r.Got = true
+		case elf.R_386_GOTPC:
+			gotpc = true
+		case elf.R_386_GOTOFF:
+			r.GotOff = true
+		default:
+			continue
+		}
+
+		if offset < 0 || offset+r.Size > len(target.Data) {
+			return nil, fmt.Errorf("Relocation at 0x%x is outside section %s.", offset, target.Name)
+		}
+
+		if !rela {
+			addend = int(int32(order.Uint32(target.Data[offset:])))
+		}
+		if r.PCRel {
+			addend += r.Size
+		}
+		r.Addend = addend
+
+		// The field is the distance to _GLOBAL_OFFSET_TABLE_ from where its
+		// addend points back to, the address __x86.get_pc_thunk.bx left in
+		// ebx for "add ebx, imm". Added to that, it's the table itself.
+		if gotpc {
+			r.Addend = 0
+		}
+
+		relocs = append(relocs, elfRelocation{r, sym})
+	}
+
+	return relocs, nil
+}
+
+// Load the PT_LOAD segments of a core file as sections, and the registers of
+// each thread from its NT_PRSTATUS note. The kernel writes the thread that
+// crashed first.
+func loadCore(f *elf.File, img *Image) error {
+	img.Format = "elf-core"
+	img.Entry = 0
+
+	for _, p := range f.Progs {
+		switch p.Type {
+
+		case elf.PT_LOAD:
+			// Segments the kernel chose not to dump have no file contents.
+			if p.Filesz == 0 {
+				continue
+			}
+
+			contents := make([]byte, p.Filesz)
+			if _, err := p.ReadAt(contents, 0); err != nil {
+				return fmt.Errorf("Error reading segment at 0x%08x: %s", p.Vaddr, err)
+			}
+
+			img.Sections = append(img.Sections, &Section{
+				Name: fmt.Sprintf("load_%08x", p.Vaddr),
+				Addr: int(p.Vaddr),
+				Data: contents,
+				Exec: p.Flags&elf.PF_X != 0,
+			})
+
+		case elf.PT_NOTE:
+			notes := make([]byte, p.Filesz)
+			if _, err := p.ReadAt(notes, 0); err != nil {
+				return fmt.Errorf("Error reading notes: %s", err)
+			}
+			if err := parseCoreNotes(f, img, notes); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(img.Threads) > 0 {
+		img.Fault = img.Threads[0].PC
+	}
+
+	return nil
+}
+
+// Walk the notes of a PT_NOTE segment, collecting a Thread for every
+// NT_PRSTATUS.
+func parseCoreNotes(f *elf.File, img *Image, notes []byte) error {
+	order := f.ByteOrder
+
+	for len(notes) >= 12 {
+		namesz := int(order.Uint32(notes[0:]))
+		descsz := int(order.Uint32(notes[4:]))
+		kind := order.Uint32(notes[8:])
+
+		desc := 12 + align4(namesz)
+		next := desc + align4(descsz)
+		if next > len(notes) || desc+descsz > len(notes) {
+			return fmt.Errorf("Truncated core note.")
+		}
+
+		if kind == NT_PRSTATUS {
+			thread, err := parsePrstatus(f.Class, order, notes[desc:desc+descsz])
+			if err != nil {
+				return err
+			}
+			img.Threads = append(img.Threads, thread)
+		}
+
+		notes = notes[next:]
+	}
+
+	return nil
+}
+
+func parsePrstatus(class elf.Class, order binary.ByteOrder, desc []byte) (*Thread, error) {
+	if class == elf.ELFCLASS64 {
+		if len(desc) < PRSTATUS_RSP_64+8 {
+			return nil, fmt.Errorf("Truncated NT_PRSTATUS note.")
+		}
+		return &Thread{
+			Id: int(order.Uint32(desc[PRSTATUS_PID_64:])),
+			PC: int(order.Uint64(desc[PRSTATUS_RIP_64:])),
+			SP: int(order.Uint64(desc[PRSTATUS_RSP_64:])),
+		}, nil
+	}
+
+	if len(desc) < PRSTATUS_ESP_32+4 {
+		return nil, fmt.Errorf("Truncated NT_PRSTATUS note.")
+	}
+	return &Thread{
+		Id: int(order.Uint32(desc[PRSTATUS_PID_32:])),
+		PC: int(order.Uint32(desc[PRSTATUS_EIP_32:])),
+		SP: int(order.Uint32(desc[PRSTATUS_ESP_32:])),
+	}, nil
+}
+
+func elfArch(machine elf.Machine) string {
+	switch machine {
+	case elf.EM_386:
+		return ARCH_X86
+	case elf.EM_X86_64:
+		return ARCH_X86_64
+	default:
+		return ""
+	}
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
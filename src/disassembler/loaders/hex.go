@@ -0,0 +1,290 @@
+package loaders
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Intel HEX record types
+const (
+	IHEX_DATA          = 0x00
+	IHEX_EOF           = 0x01
+	IHEX_EXT_SEGMENT   = 0x02
+	IHEX_START_SEGMENT = 0x03
+	IHEX_EXT_LINEAR    = 0x04
+	IHEX_START_LINEAR  = 0x05
+)
+
+// Bytes found at an address in a text record.
+type chunk struct {
+	addr int
+	data []byte
+}
+
+// Does data look like Intel HEX?
+func IsIntelHex(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) > 0 && trimmed[0] == ':' && isText(data)
+}
+
+// Does data look like a Motorola S-record file?
+func IsSRecord(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) > 1 && trimmed[0] == 'S' && trimmed[1] >= '0' && trimmed[1] <= '9' && isText(data)
+}
+
+// Does data look like free-form hex text?
+func IsHexText(data []byte) bool {
+	if !isText(data) {
+		return false
+	}
+	_, err := DecodeHexText(data)
+	return err == nil
+}
+
+// Load an Intel HEX file. Data records are placed at their address, and
+// anything between them is left unmapped.
+func LoadIntelHex(data []byte) (*Image, error) {
+	var chunks []chunk
+	var upper int
+	var err error
+
+	img := &Image{
+		Format: "ihex",
+		Arch:   ARCH_X86,
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var record []byte
+		if !strings.HasPrefix(text, ":") {
+			return nil, fmt.Errorf("Line %d: Intel HEX record must start with ':'.", line)
+		}
+		if record, err = hex.DecodeString(text[1:]); err != nil || len(record) < 5 {
+			return nil, fmt.Errorf("Line %d: Malformed Intel HEX record.", line)
+		}
+		if int(record[0]) != len(record)-5 {
+			return nil, fmt.Errorf("Line %d: Intel HEX record length mismatch.", line)
+		}
+		if checksum(record) != 0 {
+			return nil, fmt.Errorf("Line %d: Bad Intel HEX checksum.", line)
+		}
+
+		addr := int(record[1])<<8 | int(record[2])
+		payload := record[4 : len(record)-1]
+
+		switch record[3] {
+
+		case IHEX_DATA:
+			chunks = append(chunks, chunk{upper + addr, payload})
+
+		case IHEX_EOF:
+			return img, img.addChunks(chunks)
+
+		case IHEX_EXT_SEGMENT:
+			if len(payload) != 2 {
+				return nil, fmt.Errorf("Line %d: Bad extended segment address.", line)
+			}
+			upper = (int(payload[0])<<8 | int(payload[1])) << 4
+
+		case IHEX_START_SEGMENT:
+			if len(payload) != 4 {
+				return nil, fmt.Errorf("Line %d: Bad start segment address.", line)
+			}
+			cs := int(payload[0])<<8 | int(payload[1])
+			ip := int(payload[2])<<8 | int(payload[3])
+			img.Entry = cs<<4 + ip
+
+		case IHEX_EXT_LINEAR:
+			if len(payload) != 2 {
+				return nil, fmt.Errorf("Line %d: Bad extended linear address.", line)
+			}
+			upper = (int(payload[0])<<8 | int(payload[1])) << 16
+
+		case IHEX_START_LINEAR:
+			if len(payload) != 4 {
+				return nil, fmt.Errorf("Line %d: Bad start linear address.", line)
+			}
+			img.Entry = bigEndian(payload)
+
+		default:
+			return nil, fmt.Errorf("Line %d: Unknown Intel HEX record type %02x.", line, record[3])
+		}
+	}
+
+	// Tolerate a missing EOF record.
+	return img, img.addChunks(chunks)
+}
+
+// Load a Motorola S-record file. S1/S2/S3 data records are placed at their
+// address, and S7/S8/S9 records give the entry point.
+func LoadSRecord(data []byte) (*Image, error) {
+	var chunks []chunk
+	var err error
+
+	img := &Image{
+		Format: "srec",
+		Arch:   ARCH_X86,
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var record []byte
+		if len(text) < 2 || text[0] != 'S' {
+			return nil, fmt.Errorf("Line %d: S-record must start with 'S'.", line)
+		}
+		if record, err = hex.DecodeString(text[2:]); err != nil || len(record) < 1 {
+			return nil, fmt.Errorf("Line %d: Malformed S-record.", line)
+		}
+		if int(record[0]) != len(record)-1 {
+			return nil, fmt.Errorf("Line %d: S-record length mismatch.", line)
+		}
+		if checksum(record[:len(record)-1]) != ^record[len(record)-1] {
+			return nil, fmt.Errorf("Line %d: Bad S-record checksum.", line)
+		}
+
+		var size int
+		switch text[1] {
+		case '0', '5', '6':
+			// Header and record counts.
+			continue
+		case '1', '9':
+			size = 2
+		case '2', '8':
+			size = 3
+		case '3', '7':
+			size = 4
+		default:
+			return nil, fmt.Errorf("Line %d: Unknown S-record type S%c.", line, text[1])
+		}
+
+		if len(record) < size+2 {
+			return nil, fmt.Errorf("Line %d: S-record too short for its address.", line)
+		}
+
+		addr := bigEndian(record[1 : 1+size])
+
+		if text[1] >= '7' {
+			img.Entry = addr
+		} else {
+			chunks = append(chunks, chunk{addr, record[1+size : len(record)-1]})
+		}
+	}
+
+	return img, img.addChunks(chunks)
+}
+
+// Decode free-form hex text, such as "55 89 e5", "5589e5", "0x55, 0x89"
+// or "\x55\x89", into bytes.
+func DecodeHexText(data []byte) ([]byte, error) {
+	var decoded []byte
+
+	fields := strings.FieldsFunc(string(data), func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\r' || r == '\n' || r == ',' || r == '"' || r == '\'' || r == ';'
+	})
+
+	for _, field := range fields {
+		for _, token := range strings.Split(field, "\\x") {
+			token = strings.TrimPrefix(strings.TrimPrefix(token, "0x"), "0X")
+			if token == "" {
+				continue
+			}
+
+			b, err := hex.DecodeString(token)
+			if err != nil {
+				return nil, fmt.Errorf("Bad hex text: %q", token)
+			}
+			decoded = append(decoded, b...)
+		}
+	}
+
+	if len(decoded) == 0 {
+		return nil, fmt.Errorf("No hex bytes found.")
+	}
+
+	return decoded, nil
+}
+
+// Load free-form hex text as a raw file.
+func LoadHexText(data []byte, opts Options) (*Image, error) {
+	decoded, err := DecodeHexText(data)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := LoadRaw(decoded, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	img.Format = "hex"
+	return img, nil
+}
+
+// Merge chunks of record data into sections, one per contiguous run of
+// addresses, leaving gaps between them unmapped.
+func (img *Image) addChunks(chunks []chunk) error {
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].addr < chunks[j].addr })
+
+	var sec *Section
+	for _, c := range chunks {
+		if sec != nil && c.addr < sec.Addr+len(sec.Data) {
+			return fmt.Errorf("Records overlap at address 0x%08x.", c.addr)
+		}
+
+		if sec == nil || c.addr != sec.Addr+len(sec.Data) {
+			sec = &Section{
+				Name: fmt.Sprintf("%s_%08x", img.Format, c.addr),
+				Addr: c.addr,
+				Exec: true,
+			}
+			img.Sections = append(img.Sections, sec)
+		}
+
+		sec.Data = append(sec.Data, c.data...)
+	}
+
+	return nil
+}
+
+// Is data entirely printable ASCII and whitespace? Binaries almost never are.
+func isText(data []byte) bool {
+	for _, b := range data {
+		if (b < 0x20 || b > 0x7E) && b != '\t' && b != '\r' && b != '\n' {
+			return false
+		}
+	}
+	return true
+}
+
+// Sum of the bytes of a record, modulo 256.
+func checksum(record []byte) byte {
+	var sum byte
+	for _, b := range record {
+		sum += b
+	}
+	return sum
+}
+
+// Convert a big-endian byte slice of up to 4 bytes to an integer.
+func bigEndian(intbytes []byte) int {
+	var integer int
+	for _, b := range intbytes {
+		integer = integer<<8 | int(b)
+	}
+	return integer
+}
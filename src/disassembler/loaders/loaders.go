@@ -0,0 +1,231 @@
+package loaders
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Section of a loaded image, mapped at Addr.
+type Section struct {
+	Name string
+	Addr int
+	Data []byte
+	Exec bool
+}
+
+// Thread of a crashed process, from a core file or minidump.
+type Thread struct {
+	Id int
+	PC int
+	SP int
+}
+
+// Image loaded from a binary, ready to be disassembled.
+type Image struct {
+	Format   string
+	Arch     string
+	Entry    int
+	Sections []*Section
+	Threads  []*Thread
+	Fault    int // Address of the faulting instruction of a crash dump.
+	Base     int // Preferred load address of a PE image.
+
+	Relocations map[int]*Relocation // By address of the relocated field.
+	Symbols     []*Symbol           // In address order.
+	Lines       []*Line             // Source lines, in address order.
+	Unwind      []Range             // Functions from unwind tables, in address order.
+}
+
+// Architectures
+const (
+	ARCH_X86_16 = "x86-16"
+	ARCH_X86    = "x86"
+	ARCH_X86_64 = "x86-64"
+)
+
+// Loader options
+type Options struct {
+	Name   string // File name, for formats known only by their extension.
+	Arch   string // Preferred slice of a fat binary.
+	Base   int    // Load address of a raw file.
+	Skip   int    // File offset to start reading a raw file from.
+	Length int    // Number of bytes to read from a raw file, or 0 for all.
+}
+
+// Load an image from data, detecting its format from the magic bytes.
+// Anything unrecognised is loaded as a raw blob of code, see LoadRaw.
+func Load(data []byte, opts Options) (*Image, error) {
+	if len(data) >= 4 {
+		be := binary.BigEndian.Uint32(data)
+		le := binary.LittleEndian.Uint32(data)
+
+		switch {
+		case be == FAT_MAGIC:
+			return LoadFat(data, opts)
+		case le == MH_MAGIC || le == MH_MAGIC_64:
+			return LoadMachO(data)
+		case le == MDMP_MAGIC:
+			return LoadMinidump(data)
+		case string(data[:4]) == ELF_MAGIC:
+			return LoadELF(data)
+		case string(data[:2]) == "MZ" || string(data[:2]) == "ZM":
+			return LoadMZ(data)
+		}
+	}
+
+	if strings.EqualFold(filepath.Ext(opts.Name), ".com") {
+		return LoadCOM(data), nil
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte(AR_MAGIC)):
+		return LoadArchive(data)
+	case IsCOFF(data):
+		return LoadCOFF(data)
+	case IsIntelHex(data):
+		return LoadIntelHex(data)
+	case IsSRecord(data):
+		return LoadSRecord(data)
+	case IsHexText(data):
+		return LoadHexText(data, opts)
+	}
+
+	return LoadRaw(data, opts)
+}
+
+// Load data as a single executable section. The file is mapped at opts.Base,
+// so the first byte read, at opts.Skip, lives at opts.Base + opts.Skip.
+func LoadRaw(data []byte, opts Options) (*Image, error) {
+	if opts.Skip < 0 || opts.Skip > len(data) {
+		return nil, fmt.Errorf("Skip 0x%x is outside the file.", opts.Skip)
+	}
+
+	end := len(data)
+	if opts.Length > 0 {
+		if end = opts.Skip + opts.Length; end > len(data) {
+			return nil, fmt.Errorf("Length 0x%x runs past the end of the file.", opts.Length)
+		}
+	}
+
+	return &Image{
+		Format: "raw",
+		Arch:   ARCH_X86,
+		Sections: []*Section{
+			{
+				Name: "raw",
+				Addr: opts.Base + opts.Skip,
+				Data: data[opts.Skip:end],
+				Exec: true,
+			},
+		},
+	}, nil
+}
+
+// Executable sections of the image, in address order.
+func (img *Image) Code() []*Section {
+	var code []*Section
+	for _, sec := range img.Sections {
+		if sec.Exec {
+			code = append(code, sec)
+		}
+	}
+	return code
+}
+
+// Trim the executable sections down to the virtual addresses in [start, end),
+// dropping any that fall entirely outside. An end of 0 means no upper bound.
+// Data sections are kept whole so they can still be read.
+func (img *Image) Window(start int, end int) {
+	var sections []*Section
+
+	for _, sec := range img.Sections {
+		if !sec.Exec {
+			sections = append(sections, sec)
+			continue
+		}
+
+		lo, hi := sec.Addr, sec.Addr+len(sec.Data)
+		if lo < start {
+			lo = start
+		}
+		if end != 0 && hi > end {
+			hi = end
+		}
+		if lo >= hi {
+			continue
+		}
+
+		sections = append(sections, &Section{
+			Name: sec.Name,
+			Addr: lo,
+			Data: sec.Data[lo-sec.Addr : hi-sec.Addr],
+			Exec: sec.Exec,
+		})
+	}
+
+	img.Sections = sections
+}
+
+// Split the section containing addr in two, so that decoding starts afresh at
+// addr. This keeps a linear sweep from running through an address we care
+// about, such as the faulting instruction of a crash dump.
+func (img *Image) Split(addr int) {
+	for i, sec := range img.Sections {
+		if addr <= sec.Addr || addr >= sec.Addr+len(sec.Data) {
+			continue
+		}
+
+		tail := &Section{
+			Name: sec.Name,
+			Addr: addr,
+			Data: sec.Data[addr-sec.Addr:],
+			Exec: sec.Exec,
+		}
+		sec.Data = sec.Data[:addr-sec.Addr]
+
+		img.Sections = append(img.Sections[:i+1], append([]*Section{tail}, img.Sections[i+1:]...)...)
+		return
+	}
+}
+
+// Find the section containing addr.
+func (img *Image) SectionAt(addr int) *Section {
+	for _, sec := range img.Sections {
+		if addr >= sec.Addr && addr < sec.Addr+len(sec.Data) {
+			return sec
+		}
+	}
+	return nil
+}
+
+// Read size bytes at the virtual address addr.
+func (img *Image) Read(addr int, size int) ([]byte, error) {
+	sec := img.SectionAt(addr)
+	if sec == nil || addr+size > sec.Addr+len(sec.Data) {
+		return nil, fmt.Errorf("Address 0x%08x is not mapped.", addr)
+	}
+	start := addr - sec.Addr
+	return sec.Data[start : start+size], nil
+}
+
+// Read the NUL-terminated string at the virtual address addr.
+func (img *Image) ReadString(addr int) string {
+	sec := img.SectionAt(addr)
+	if sec == nil {
+		return ""
+	}
+
+	data := sec.Data[addr-sec.Addr:]
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
+
+// Buffer over the data of a section.
+func (sec *Section) Buffer() *bytes.Buffer {
+	return bytes.NewBuffer(sec.Data)
+}
@@ -0,0 +1,118 @@
+package loaders
+
+import (
+	"bytes"
+	"debug/macho"
+	"fmt"
+)
+
+// Mach-O magic numbers and load commands
+const (
+	FAT_MAGIC   = 0xCAFEBABE
+	MH_MAGIC    = 0xFEEDFACE
+	MH_MAGIC_64 = 0xFEEDFACF
+
+	LC_MAIN = 0x80000028
+
+	S_ZEROFILL               = 0x01
+	S_GB_ZEROFILL            = 0x0C
+	S_THREAD_LOCAL_ZEROFILL  = 0x12
+	S_ATTR_SOME_INSTRUCTIONS = 0x00000400
+	S_ATTR_PURE_INSTRUCTIONS = 0x80000000
+	SECTION_TYPE             = 0x000000FF
+)
+
+// Load the x86 or x86-64 slice of a universal binary. If opts.Arch is empty,
+// the 32-bit slice is preferred since that's what the decoder understands.
+func LoadFat(data []byte, opts Options) (*Image, error) {
+	fat, err := macho.NewFatFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer fat.Close()
+
+	var slice *macho.FatArch
+	for i := range fat.Arches {
+		arch := &fat.Arches[i]
+		name := machoArch(arch.Cpu)
+
+		if name == "" || (opts.Arch != "" && opts.Arch != name) {
+			continue
+		}
+		if slice == nil || name == ARCH_X86 {
+			slice = arch
+		}
+	}
+
+	if slice == nil {
+		return nil, fmt.Errorf("No x86 slice found in fat binary.")
+	}
+
+	end := int(slice.Offset) + int(slice.Size)
+	if end > len(data) {
+		return nil, fmt.Errorf("Fat slice extends past end of file.")
+	}
+
+	return LoadMachO(data[slice.Offset:end])
+}
+
+// Load the segments and sections of a thin Mach-O binary.
+func LoadMachO(data []byte) (*Image, error) {
+	f, err := macho.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img := &Image{
+		Format: "mach-o",
+		Arch:   machoArch(f.Cpu),
+	}
+	if img.Arch == "" {
+		return nil, fmt.Errorf("Unsupported Mach-O CPU type: %s", f.Cpu)
+	}
+
+	// Sections carry the LC_SEGMENT/LC_SEGMENT_64 they were declared in.
+	for _, s := range f.Sections {
+		kind := s.Flags & SECTION_TYPE
+		if kind == S_ZEROFILL || kind == S_GB_ZEROFILL || kind == S_THREAD_LOCAL_ZEROFILL {
+			continue
+		}
+
+		var contents []byte
+		if contents, err = s.Data(); err != nil {
+			return nil, fmt.Errorf("Error reading section %s,%s: %s", s.Seg, s.Name, err)
+		}
+
+		img.Sections = append(img.Sections, &Section{
+			Name: s.Seg + "," + s.Name,
+			Addr: int(s.Addr),
+			Data: contents,
+			Exec: s.Flags&(S_ATTR_SOME_INSTRUCTIONS|S_ATTR_PURE_INSTRUCTIONS) != 0 || (s.Seg == "__TEXT" && s.Name == "__text"),
+		})
+	}
+
+	// LC_MAIN holds the entry point as a file offset into __TEXT.
+	text := f.Segment("__TEXT")
+	for _, l := range f.Loads {
+		raw := l.Raw()
+		if len(raw) < 16 || f.ByteOrder.Uint32(raw) != LC_MAIN || text == nil {
+			continue
+		}
+		entryoff := f.ByteOrder.Uint64(raw[8:])
+		img.Entry = int(text.Addr + entryoff - text.Offset)
+	}
+
+	return img, nil
+}
+
+func machoArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.Cpu386:
+		return ARCH_X86
+	case macho.CpuAmd64:
+		return ARCH_X86_64
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,191 @@
+package loaders
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Minidump magic, stream types and processor architectures
+const (
+	MDMP_MAGIC = 0x504D444D // "MDMP"
+
+	THREAD_LIST_STREAM   = 3
+	MEMORY_LIST_STREAM   = 5
+	EXCEPTION_STREAM     = 6
+	SYSTEM_INFO_STREAM   = 7
+	MEMORY64_LIST_STREAM = 9
+
+	PROCESSOR_ARCH_INTEL = 0
+	PROCESSOR_ARCH_AMD64 = 9
+)
+
+// Sizes of the minidump structures we walk arrays of.
+const (
+	MINIDUMP_THREAD_SIZE   = 48
+	MINIDUMP_MEMORY_SIZE   = 16
+	MINIDUMP_MEMORY64_SIZE = 16
+)
+
+// Offsets of the registers in the x86 and AMD64 CONTEXT structures.
+const (
+	CONTEXT_EIP_32 = 184
+	CONTEXT_ESP_32 = 196
+
+	CONTEXT_RSP_64 = 152
+	CONTEXT_RIP_64 = 248
+)
+
+// Load the memory ranges, thread contexts and exception record of a
+// Windows minidump.
+func LoadMinidump(data []byte) (*Image, error) {
+	le := binary.LittleEndian
+
+	if len(data) < 32 || le.Uint32(data) != MDMP_MAGIC {
+		return nil, fmt.Errorf("Not a minidump.")
+	}
+
+	img := &Image{
+		Format: "minidump",
+		Arch:   ARCH_X86,
+	}
+
+	count := int(le.Uint32(data[8:]))
+	dir := int(le.Uint32(data[12:]))
+
+	// Read the stream directory first. The system info stream decides how
+	// the thread contexts are laid out, so it must be seen before them.
+	streams := make(map[uint32][]byte)
+	for i := 0; i < count; i++ {
+		entry, err := slice(data, dir+i*12, 12)
+		if err != nil {
+			return nil, err
+		}
+		stream, err := slice(data, int(le.Uint32(entry[8:])), int(le.Uint32(entry[4:])))
+		if err != nil {
+			return nil, err
+		}
+		streams[le.Uint32(entry)] = stream
+	}
+
+	if info, ok := streams[SYSTEM_INFO_STREAM]; ok && len(info) >= 2 {
+		switch le.Uint16(info) {
+		case PROCESSOR_ARCH_INTEL:
+			img.Arch = ARCH_X86
+		case PROCESSOR_ARCH_AMD64:
+			img.Arch = ARCH_X86_64
+		default:
+			return nil, fmt.Errorf("Unsupported minidump processor architecture: %d", le.Uint16(info))
+		}
+	}
+
+	if list, ok := streams[MEMORY_LIST_STREAM]; ok && len(list) >= 4 {
+		for i := 0; i < int(le.Uint32(list)); i++ {
+			desc, err := slice(list, 4+i*MINIDUMP_MEMORY_SIZE, MINIDUMP_MEMORY_SIZE)
+			if err != nil {
+				return nil, err
+			}
+			contents, err := slice(data, int(le.Uint32(desc[12:])), int(le.Uint32(desc[8:])))
+			if err != nil {
+				return nil, err
+			}
+			img.addMemory(int(le.Uint64(desc)), contents)
+		}
+	}
+
+	if list, ok := streams[MEMORY64_LIST_STREAM]; ok && len(list) >= 16 {
+		rva := int(le.Uint64(list[8:]))
+		for i := 0; i < int(le.Uint64(list)); i++ {
+			desc, err := slice(list, 16+i*MINIDUMP_MEMORY64_SIZE, MINIDUMP_MEMORY64_SIZE)
+			if err != nil {
+				return nil, err
+			}
+			size := int(le.Uint64(desc[8:]))
+			contents, err := slice(data, rva, size)
+			if err != nil {
+				return nil, err
+			}
+			img.addMemory(int(le.Uint64(desc)), contents)
+			rva += size
+		}
+	}
+
+	if list, ok := streams[THREAD_LIST_STREAM]; ok && len(list) >= 4 {
+		for i := 0; i < int(le.Uint32(list)); i++ {
+			thread, err := slice(list, 4+i*MINIDUMP_THREAD_SIZE, MINIDUMP_THREAD_SIZE)
+			if err != nil {
+				return nil, err
+			}
+			t, err := img.minidumpContext(data, thread[40:])
+			if err != nil {
+				return nil, err
+			}
+			t.Id = int(le.Uint32(thread))
+			img.Threads = append(img.Threads, t)
+		}
+	}
+
+	if exception, ok := streams[EXCEPTION_STREAM]; ok && len(exception) >= 32 {
+		img.Fault = int(le.Uint64(exception[24:]))
+	}
+
+	sort.Slice(img.Sections, func(i, j int) bool { return img.Sections[i].Addr < img.Sections[j].Addr })
+
+	// Minidumps don't record page permissions, so treat the memory around
+	// each thread's instruction pointer as code.
+	for _, t := range img.Threads {
+		if sec := img.SectionAt(t.PC); sec != nil {
+			sec.Exec = true
+		}
+	}
+	if sec := img.SectionAt(img.Fault); sec != nil {
+		sec.Exec = true
+	}
+
+	return img, nil
+}
+
+// Read the instruction and stack pointers out of the CONTEXT referenced by
+// a location descriptor.
+func (img *Image) minidumpContext(data []byte, location []byte) (*Thread, error) {
+	le := binary.LittleEndian
+
+	context, err := slice(data, int(le.Uint32(location[4:])), int(le.Uint32(location)))
+	if err != nil {
+		return nil, err
+	}
+
+	if img.Arch == ARCH_X86_64 {
+		if len(context) < CONTEXT_RIP_64+8 {
+			return nil, fmt.Errorf("Truncated AMD64 thread context.")
+		}
+		return &Thread{
+			PC: int(le.Uint64(context[CONTEXT_RIP_64:])),
+			SP: int(le.Uint64(context[CONTEXT_RSP_64:])),
+		}, nil
+	}
+
+	if len(context) < CONTEXT_ESP_32+4 {
+		return nil, fmt.Errorf("Truncated x86 thread context.")
+	}
+	return &Thread{
+		PC: int(le.Uint32(context[CONTEXT_EIP_32:])),
+		SP: int(le.Uint32(context[CONTEXT_ESP_32:])),
+	}, nil
+}
+
+func (img *Image) addMemory(addr int, contents []byte) {
+	img.Sections = append(img.Sections, &Section{
+		Name: fmt.Sprintf("memory_%08x", addr),
+		Addr: addr,
+		Data: contents,
+	})
+}
+
+// Bounds-checked data[offset:offset+size].
+func slice(data []byte, offset int, size int) ([]byte, error) {
+	if offset < 0 || size < 0 || offset+size > len(data) {
+		return nil, fmt.Errorf("Minidump reference 0x%x+0x%x is outside the file.", offset, size)
+	}
+	return data[offset : offset+size], nil
+}
@@ -0,0 +1,185 @@
+package loaders
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DOS and NE header constants
+const (
+	MZ_HEADER_SIZE = 0x1C
+	MZ_LFANEW      = 0x3C
+	COM_BASE       = 0x100
+
+	NE_HEADER_SIZE  = 0x40
+	NE_SEGMENT_SIZE = 8
+	NE_SEG_DATA     = 0x0001
+)
+
+// Load an MZ executable. If its header points on to a PE or NE header, the
+// file is loaded as that instead; otherwise it's a plain DOS program.
+func LoadMZ(data []byte) (*Image, error) {
+	le := binary.LittleEndian
+
+	if len(data) < MZ_HEADER_SIZE {
+		return nil, fmt.Errorf("Truncated MZ header.")
+	}
+
+	// Only newer executables have room for e_lfanew, which they signal by
+	// putting the relocation table after it.
+	if le.Uint16(data[0x18:]) >= 0x40 && len(data) >= 0x40 {
+		lfanew := int(le.Uint32(data[MZ_LFANEW:]))
+		if lfanew > 0 && lfanew+4 <= len(data) {
+			switch {
+			case string(data[lfanew:lfanew+4]) == "PE\x00\x00":
+				return LoadPE(data)
+			case string(data[lfanew:lfanew+2]) == "NE":
+				return LoadNE(data, lfanew)
+			}
+		}
+	}
+
+	return LoadDOS(data)
+}
+
+// Load a DOS MZ executable. The load module is mapped at linear address 0, as
+// if loaded at segment 0, so the initial CS:IP is the entry point. Words the
+// loader would fix up with the load segment are named after the segment they
+// refer to.
+func LoadDOS(data []byte) (*Image, error) {
+	le := binary.LittleEndian
+
+	lastpage := int(le.Uint16(data[0x02:]))
+	pages := int(le.Uint16(data[0x04:]))
+	nrelocs := int(le.Uint16(data[0x06:]))
+	header := int(le.Uint16(data[0x08:])) * 16
+	ip := int(le.Uint16(data[0x14:]))
+	cs := int(le.Uint16(data[0x16:]))
+	reloctab := int(le.Uint16(data[0x18:]))
+
+	size := pages * 512
+	if lastpage != 0 {
+		size -= 512 - lastpage
+	}
+	if size > len(data) {
+		size = len(data)
+	}
+	if header > size {
+		return nil, fmt.Errorf("MZ header is larger than the file.")
+	}
+
+	module := data[header:size]
+
+	img := &Image{
+		Format: "mz",
+		Arch:   ARCH_X86_16,
+		Entry:  (cs<<4 + ip) & 0xFFFFF,
+		Sections: []*Section{
+			{
+				Name: "load",
+				Addr: 0,
+				Data: module,
+				Exec: true,
+			},
+		},
+		Relocations: make(map[int]*Relocation),
+	}
+
+	for i := 0; i < nrelocs; i++ {
+		entry := reloctab + i*4
+		if entry+4 > len(data) {
+			return nil, fmt.Errorf("Truncated MZ relocation table.")
+		}
+
+		addr := int(le.Uint16(data[entry+2:]))<<4 + int(le.Uint16(data[entry:]))
+		if addr+2 > len(module) {
+			continue
+		}
+
+		img.Relocations[addr] = &Relocation{
+			Addr:   addr,
+			Size:   2,
+			Symbol: fmt.Sprintf("seg_%04x", le.Uint16(module[addr:])),
+		}
+	}
+
+	return img, nil
+}
+
+// Load a .COM program, which is just code loaded at offset 0x100 of its
+// segment.
+func LoadCOM(data []byte) *Image {
+	return &Image{
+		Format: "com",
+		Arch:   ARCH_X86_16,
+		Entry:  COM_BASE,
+		Sections: []*Section{
+			{
+				Name: "com",
+				Addr: COM_BASE,
+				Data: data,
+				Exec: true,
+			},
+		},
+	}
+}
+
+// Load the segments of a 16-bit Windows or OS/2 NE executable from its
+// segment table. Segments are selectors rather than linear addresses, so
+// segment n is mapped at n:0000, that is, address n<<16.
+func LoadNE(data []byte, ne int) (*Image, error) {
+	le := binary.LittleEndian
+
+	if ne+NE_HEADER_SIZE > len(data) {
+		return nil, fmt.Errorf("Truncated NE header.")
+	}
+	header := data[ne:]
+
+	ip := int(le.Uint16(header[0x14:]))
+	cs := int(le.Uint16(header[0x16:]))
+	count := int(le.Uint16(header[0x1C:]))
+	segtab := ne + int(le.Uint16(header[0x22:]))
+	shift := uint(le.Uint16(header[0x32:]))
+	if shift == 0 {
+		shift = 9
+	}
+
+	img := &Image{
+		Format: "ne",
+		Arch:   ARCH_X86_16,
+	}
+	if cs != 0 {
+		img.Entry = cs<<16 + ip
+	}
+
+	for i := 0; i < count; i++ {
+		entry := segtab + i*NE_SEGMENT_SIZE
+		if entry+NE_SEGMENT_SIZE > len(data) {
+			return nil, fmt.Errorf("Truncated NE segment table.")
+		}
+
+		offset := int(le.Uint16(data[entry:])) << shift
+		length := int(le.Uint16(data[entry+2:]))
+		flags := le.Uint16(data[entry+4:])
+
+		// Segments with no file data are zero-filled at load time.
+		if offset == 0 {
+			continue
+		}
+		if length == 0 {
+			length = 0x10000
+		}
+		if offset+length > len(data) {
+			return nil, fmt.Errorf("NE segment %d extends past the end of the file.", i+1)
+		}
+
+		img.Sections = append(img.Sections, &Section{
+			Name: fmt.Sprintf("seg%d", i+1),
+			Addr: (i + 1) << 16,
+			Data: data[offset : offset+length],
+			Exec: flags&NE_SEG_DATA == 0,
+		})
+	}
+
+	return img, nil
+}
@@ -0,0 +1,258 @@
+package loaders
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Load a PE executable or DLL, with its sections mapped at their virtual
+// addresses relative to the preferred image base.
+func LoadPE(data []byte) (*Image, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img := &Image{
+		Format: "pe",
+	}
+
+	switch f.Machine {
+	case pe.IMAGE_FILE_MACHINE_I386:
+		img.Arch = ARCH_X86
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		img.Arch = ARCH_X86_64
+	default:
+		return nil, fmt.Errorf("Unsupported PE machine: 0x%04x", f.Machine)
+	}
+
+	var entry int
+	switch header := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		img.Base = int(header.ImageBase)
+		entry = int(header.AddressOfEntryPoint)
+	case *pe.OptionalHeader64:
+		img.Base = int(header.ImageBase)
+		entry = int(header.AddressOfEntryPoint)
+	default:
+		return nil, fmt.Errorf("PE file has no optional header.")
+	}
+
+	if entry != 0 {
+		img.Entry = img.Base + entry
+	}
+
+	addrs := make(map[int]int)
+
+	for i, s := range f.Sections {
+		addrs[i+1] = img.Base + int(s.VirtualAddress)
+
+		contents, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading section %s: %s", s.Name, err)
+		}
+
+		// Raw data is padded out to the file alignment.
+		if s.VirtualSize != 0 && int(s.VirtualSize) < len(contents) {
+			contents = contents[:s.VirtualSize]
+		}
+		if len(contents) == 0 {
+			continue
+		}
+
+		img.Sections = append(img.Sections, &Section{
+			Name: s.Name,
+			Addr: img.Base + int(s.VirtualAddress),
+			Data: contents,
+			Exec: s.Characteristics&(pe.IMAGE_SCN_CNT_CODE|pe.IMAGE_SCN_MEM_EXECUTE) != 0,
+		})
+	}
+
+	// MinGW leaves a COFF symbol table in the image; MSVC doesn't.
+	syms, err := coffSymbols(f, addrs)
+	if err != nil {
+		return nil, err
+	}
+	img.AddSymbols(syms)
+
+	if dir, ok := peDirectory(f, pe.IMAGE_DIRECTORY_ENTRY_EXPORT); ok {
+		img.AddSymbols(img.peExports(dir))
+	}
+
+	if dir, ok := peDirectory(f, pe.IMAGE_DIRECTORY_ENTRY_IMPORT); ok {
+		imports := img.peImports(dir)
+		img.AddSymbols(imports)
+		img.AddSymbols(img.peThunks(imports))
+	}
+
+	return img, nil
+}
+
+// IAT slots of a PE image's imported functions, named "__imp_CreateFileW",
+// from its import directory. Functions imported by ordinal are named after
+// their DLL, e.g. "__imp_ws2_32.dll#23".
+func (img *Image) peImports(dir pe.DataDirectory) []*Symbol {
+	var syms []*Symbol
+	le := binary.LittleEndian
+	size := 4
+
+	for desc := img.Base + int(dir.VirtualAddress); ; desc += 20 {
+		descriptor, err := img.Read(desc, 20)
+		if err != nil {
+			break
+		}
+
+		lookup := int(le.Uint32(descriptor))
+		dll := img.ReadString(img.Base + int(le.Uint32(descriptor[12:])))
+		iat := int(le.Uint32(descriptor[16:]))
+		if iat == 0 {
+			break
+		}
+
+		// The IAT holds the same entries as the lookup table until the
+		// loader binds it, and some linkers leave the lookup table out.
+		if lookup == 0 {
+			lookup = iat
+		}
+
+		for i := 0; ; i++ {
+			entry, err := img.Read(img.Base+lookup+i*size, size)
+			if err != nil {
+				break
+			}
+
+			value := le.Uint32(entry)
+			if value == 0 {
+				break
+			}
+
+			var name string
+			if value>>31 == 1 {
+				name = fmt.Sprintf("%s#%d", dll, value&0xFFFF)
+			} else {
+				// Skip the hint.
+				name = img.ReadString(img.Base + int(value&0x7FFFFFFF) + 2)
+			}
+
+			syms = append(syms, &Symbol{
+				Name:   "__imp_" + name,
+				Addr:   img.Base + iat + i*size,
+				Size:   size,
+				Import: true,
+			})
+		}
+	}
+
+	return syms
+}
+
+// Jump thunks, "jmp [__imp_CreateFileW]", that the linker adds for calls to
+// imported functions not declared dllimport. Each is named after the
+// function it jumps to.
+func (img *Image) peThunks(imports []*Symbol) []*Symbol {
+	var syms []*Symbol
+
+	slots := make(map[int]*Symbol)
+	for _, sym := range imports {
+		slots[sym.Addr] = sym
+	}
+
+	for _, sec := range img.Code() {
+		for i := 0; i+6 <= len(sec.Data); i++ {
+			if sec.Data[i] != 0xFF || sec.Data[i+1] != 0x25 {
+				continue
+			}
+
+			slot := int(binary.LittleEndian.Uint32(sec.Data[i+2:]))
+
+			if imp, ok := slots[slot]; ok {
+				syms = append(syms, &Symbol{
+					Name:   strings.TrimPrefix(imp.Name, "__imp_"),
+					Addr:   sec.Addr + i,
+					Size:   6,
+					Func:   true,
+					Import: true,
+				})
+			}
+		}
+	}
+
+	return syms
+}
+
+// Data directory of a PE image, if present.
+func peDirectory(f *pe.File, index int) (pe.DataDirectory, bool) {
+	var dirs []pe.DataDirectory
+	var count uint32
+
+	switch header := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		dirs, count = header.DataDirectory[:], header.NumberOfRvaAndSizes
+	case *pe.OptionalHeader64:
+		dirs, count = header.DataDirectory[:], header.NumberOfRvaAndSizes
+	}
+
+	if index >= len(dirs) || uint32(index) >= count || dirs[index].VirtualAddress == 0 {
+		return pe.DataDirectory{}, false
+	}
+	return dirs[index], true
+}
+
+// Exported functions of a PE image, from its export directory. Exports
+// without a name are called by ordinal, e.g. "ordinal_12". Forwarders to
+// other DLLs have no code here and are left out.
+func (img *Image) peExports(dir pe.DataDirectory) []*Symbol {
+	var syms []*Symbol
+
+	export, err := img.Read(img.Base+int(dir.VirtualAddress), 40)
+	if err != nil {
+		return nil
+	}
+
+	le := binary.LittleEndian
+	ordinalBase := int(le.Uint32(export[16:]))
+	nfuncs := int(le.Uint32(export[20:]))
+	nnames := int(le.Uint32(export[24:]))
+	funcs := img.Base + int(le.Uint32(export[28:]))
+	names := img.Base + int(le.Uint32(export[32:]))
+	ordinals := img.Base + int(le.Uint32(export[36:]))
+
+	named := make(map[int]string)
+	for i := 0; i < nnames; i++ {
+		name, err1 := img.Read(names+i*4, 4)
+		ordinal, err2 := img.Read(ordinals+i*2, 2)
+		if err1 != nil || err2 != nil {
+			break
+		}
+		named[int(le.Uint16(ordinal))] = img.ReadString(img.Base + int(le.Uint32(name)))
+	}
+
+	for i := 0; i < nfuncs; i++ {
+		rva, err := img.Read(funcs+i*4, 4)
+		if err != nil {
+			break
+		}
+
+		addr := int(le.Uint32(rva))
+		if addr == 0 || (addr >= int(dir.VirtualAddress) && addr < int(dir.VirtualAddress+dir.Size)) {
+			continue
+		}
+
+		name, ok := named[i]
+		if !ok {
+			name = fmt.Sprintf("ordinal_%d", ordinalBase+i)
+		}
+
+		syms = append(syms, &Symbol{
+			Name: name,
+			Addr: img.Base + addr,
+			Func: true,
+		})
+	}
+
+	return syms
+}
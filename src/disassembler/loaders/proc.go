@@ -0,0 +1,170 @@
+package loaders
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Memory mapping of a live process, from /proc/<pid>/maps.
+type Mapping struct {
+	Start  int
+	End    int
+	Perms  string
+	Offset int
+	Path   string
+}
+
+// Is the mapping executable?
+func (m *Mapping) Exec() bool {
+	return strings.Contains(m.Perms, "x")
+}
+
+// Read the memory mappings of a process.
+func ReadMaps(pid int) ([]*Mapping, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var maps []*Mapping
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// start-end perms offset dev inode [path]
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+
+		bounds := strings.SplitN(fields[0], "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("Bad mapping: %s", scanner.Text())
+		}
+
+		m := &Mapping{Perms: fields[1]}
+		var start, end, offset uint64
+
+		if start, err = strconv.ParseUint(bounds[0], 16, 64); err != nil {
+			return nil, err
+		}
+		if end, err = strconv.ParseUint(bounds[1], 16, 64); err != nil {
+			return nil, err
+		}
+		if offset, err = strconv.ParseUint(fields[2], 16, 64); err != nil {
+			return nil, err
+		}
+
+		// Kernel mappings such as [vsyscall] can't be read from mem anyway.
+		if end > math.MaxInt64 {
+			continue
+		}
+		m.Start, m.End, m.Offset = int(start), int(end), int(offset)
+
+		if len(fields) > 5 {
+			m.Path = strings.Join(fields[5:], " ")
+		}
+
+		maps = append(maps, m)
+	}
+
+	return maps, scanner.Err()
+}
+
+// Select the mappings of a process to disassemble. sel may be an address
+// inside the mapping, or part of the mapped file's path, such as "libc" or
+// "[heap]". With no selector, the executable mappings of the main program
+// are chosen.
+func SelectMaps(pid int, maps []*Mapping, sel string) ([]*Mapping, error) {
+	var selected []*Mapping
+
+	if addr, err := strconv.ParseInt(sel, 0, 64); err == nil {
+		for _, m := range maps {
+			if int(addr) >= m.Start && int(addr) < m.End {
+				return []*Mapping{m}, nil
+			}
+		}
+		return nil, fmt.Errorf("No mapping contains address 0x%x.", addr)
+	}
+
+	if sel == "" {
+		exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range maps {
+			if m.Exec() && m.Path == exe {
+				selected = append(selected, m)
+			}
+		}
+	} else {
+		for _, m := range maps {
+			if m.Exec() && strings.Contains(m.Path, sel) {
+				selected = append(selected, m)
+			}
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("No executable mapping matches %q.", sel)
+	}
+	return selected, nil
+}
+
+// Load the selected mappings of a live process out of /proc/<pid>/mem.
+func LoadProcess(pid int, sel string) (*Image, error) {
+	maps, err := ReadMaps(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	if maps, err = SelectMaps(pid, maps, sel); err != nil {
+		return nil, err
+	}
+
+	mem, err := os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer mem.Close()
+
+	img := &Image{
+		Format: "process",
+		Arch:   ARCH_X86,
+	}
+
+	// The process's executable says what code it runs.
+	if f, err := elf.Open(fmt.Sprintf("/proc/%d/exe", pid)); err == nil {
+		if arch := elfArch(f.Machine); arch != "" {
+			img.Arch = arch
+		}
+		f.Close()
+	}
+
+	for _, m := range maps {
+		contents := make([]byte, m.End-m.Start)
+		if _, err = mem.ReadAt(contents, int64(m.Start)); err != nil {
+			return nil, fmt.Errorf("Error reading mapping at 0x%08x: %s", m.Start, err)
+		}
+
+		name := m.Path
+		if name == "" {
+			name = "[anon]"
+		}
+
+		// Selected mappings are disassembled, whatever their permissions.
+		img.Sections = append(img.Sections, &Section{
+			Name: name,
+			Addr: m.Start,
+			Data: contents,
+			Exec: true,
+		})
+	}
+
+	return img, nil
+}
@@ -0,0 +1,89 @@
+package loaders
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Relocation of a field in a relocatable object. For a PC-relative field,
+// the address referred to is measured from the end of the instruction
+// rather than the field itself, so Addend is normalised to what needs adding
+// to the symbol when the field is the last thing in the instruction.
+type Relocation struct {
+	Addr    int // Address of the relocated field.
+	Size    int
+	Symbol  string
+	Value   int // Address of the symbol, if Defined.
+	Addend  int
+	PCRel   bool
+	Got     bool // Refers to the symbol's GOT entry, not the symbol.
+	GotOff  bool // Holds the symbol's offset from the GOT, not its address.
+	Defined bool
+}
+
+// Name of the address the relocation refers to, as seen from an instruction
+// ending at next, e.g. "printf" or "table+0x8".
+func (r *Relocation) Target(next int) string {
+	addend := r.Addend
+	if r.PCRel {
+		addend += next - (r.Addr + r.Size)
+	}
+
+	name := r.Symbol
+	if r.Got {
+		name += "@GOT"
+	}
+	if r.GotOff {
+		name += "@GOTOFF"
+	}
+
+	switch {
+	case addend > 0:
+		return fmt.Sprintf("%s+0x%x", name, addend)
+	case addend < 0:
+		return fmt.Sprintf("%s-0x%x", name, -addend)
+	default:
+		return name
+	}
+}
+
+// Patch the relocated field in data, which is mapped at base, so a defined
+// symbol's address shows through in the disassembly. Undefined symbols, GOT
+// entries and offsets from the GOT are left alone; there's nothing to point
+// them at.
+func (r *Relocation) Apply(data []byte, base int) {
+	if !r.Defined || r.Got || r.GotOff {
+		return
+	}
+
+	field := r.Addr - base
+	if field < 0 || field+r.Size > len(data) {
+		return
+	}
+
+	value := r.Value + r.Addend
+	if r.PCRel {
+		value -= r.Addr + r.Size
+	}
+
+	binary.LittleEndian.PutUint32(data[field:], uint32(value))
+}
+
+// Find the relocation of a field within [addr, addr+size).
+func (img *Image) RelocationIn(addr int, size int) *Relocation {
+	for a := addr; a < addr+size; a++ {
+		if r, ok := img.Relocations[a]; ok {
+			return r
+		}
+	}
+	return nil
+}
+
+// Lay out a section of a relocatable object after end, honouring its
+// alignment.
+func layout(end int, align int) int {
+	if align > 1 {
+		end = (end + align - 1) / align * align
+	}
+	return end
+}
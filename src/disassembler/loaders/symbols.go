@@ -0,0 +1,128 @@
+package loaders
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Named address in an image.
+type Symbol struct {
+	Name   string
+	Addr   int
+	Size   int // 0 if unknown.
+	Func   bool
+	Import bool // Import slot, or stub that jumps through one.
+}
+
+// MSVC map file section:offset column, e.g. "0001:000012a0".
+var msvcAddress = regexp.MustCompile(`^[0-9A-Fa-f]{4}:[0-9A-Fa-f]{8}$`)
+
+// Add symbols to the image, keeping them in address order.
+func (img *Image) AddSymbols(syms []*Symbol) {
+	img.Symbols = append(img.Symbols, syms...)
+	sort.SliceStable(img.Symbols, func(i, j int) bool { return img.Symbols[i].Addr < img.Symbols[j].Addr })
+}
+
+// Find the symbol covering addr, along with addr's offset into it. A symbol
+// of unknown size covers everything up to the next symbol in its section.
+func (img *Image) SymbolAt(addr int) (*Symbol, int) {
+	i := sort.Search(len(img.Symbols), func(i int) bool { return img.Symbols[i].Addr > addr }) - 1
+	if i < 0 {
+		return nil, 0
+	}
+
+	// Prefer the first of several symbols at the same address.
+	for i > 0 && img.Symbols[i-1].Addr == img.Symbols[i].Addr {
+		i--
+	}
+	sym := img.Symbols[i]
+
+	switch {
+	case sym.Addr == addr:
+		return sym, 0
+	case sym.Size > 0 && addr >= sym.Addr+sym.Size:
+		return nil, 0
+	case sym.Size == 0 && img.SectionAt(sym.Addr) != img.SectionAt(addr):
+		return nil, 0
+	}
+
+	return sym, addr - sym.Addr
+}
+
+// Name of addr in terms of the symbol covering it, e.g. "main" or
+// "main+0x12", or "" if no symbol covers it.
+func (img *Image) SymbolName(addr int) string {
+	sym, offset := img.SymbolAt(addr)
+	switch {
+	case sym == nil:
+		return ""
+	case offset == 0:
+		return sym.Name
+	default:
+		return fmt.Sprintf("%s+0x%x", sym.Name, offset)
+	}
+}
+
+// Parse a symbol file. Lines in any of these forms are understood, and
+// anything else, such as the rest of a linker map, is skipped:
+//
+//	0804849b T main             nm
+//	0804849b main               plain address and name
+//	0x0000000000401126  main    GNU ld map
+//	0001:00000000  _main  00401000 f  main.obj    MSVC map
+func ParseSymbolFile(data []byte) ([]*Symbol, error) {
+	var syms []*Symbol
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sym := &Symbol{}
+		var err error
+
+		switch {
+
+		case len(fields) >= 3 && msvcAddress.MatchString(fields[0]):
+			sym.Name = fields[1]
+			sym.Addr, err = parseHex(fields[2])
+			sym.Func = len(fields) >= 4 && fields[3] == "f"
+
+		case len(fields) == 3 && len(fields[1]) == 1 && isLetter(fields[1][0]):
+			sym.Name = fields[2]
+			sym.Addr, err = parseHex(fields[0])
+			sym.Func = strings.ContainsAny(fields[1], "Tt")
+
+		case len(fields) == 2:
+			sym.Name = fields[1]
+			sym.Addr, err = parseHex(fields[0])
+
+		default:
+			continue
+		}
+
+		if err != nil {
+			continue
+		}
+		syms = append(syms, sym)
+	}
+
+	if len(syms) == 0 {
+		return nil, fmt.Errorf("No symbols found.")
+	}
+
+	return syms, scanner.Err()
+}
+
+func parseHex(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	n, err := strconv.ParseUint(s, 16, 64)
+	return int(n), err
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z')
+}
@@ -0,0 +1,245 @@
+package loaders
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"sort"
+)
+
+// Extent of a function, from an unwind table.
+type Range struct {
+	Start int
+	End   int
+}
+
+// DWARF pointer encodings, as used in .eh_frame
+const (
+	DW_EH_PE_absptr  = 0x00
+	DW_EH_PE_uleb128 = 0x01
+	DW_EH_PE_udata2  = 0x02
+	DW_EH_PE_udata4  = 0x03
+	DW_EH_PE_udata8  = 0x04
+	DW_EH_PE_sleb128 = 0x09
+	DW_EH_PE_sdata2  = 0x0A
+	DW_EH_PE_sdata4  = 0x0B
+	DW_EH_PE_sdata8  = 0x0C
+	DW_EH_PE_pcrel   = 0x10
+	DW_EH_PE_omit    = 0xFF
+)
+
+// Add the functions described by the FDEs of an ELF file's .eh_frame to the
+// image's unwind ranges. A malformed table is read as far as it makes sense.
+func (img *Image) elfEHFrame(f *elf.File) {
+	s := f.Section(".eh_frame")
+	if s == nil || s.Type == elf.SHT_NOBITS {
+		return
+	}
+	data, err := s.Data()
+	if err != nil {
+		return
+	}
+
+	ptrsize := 4
+	if f.Class == elf.ELFCLASS64 {
+		ptrsize = 8
+	}
+
+	// FDE pointer encodings, by CIE offset.
+	encodings := make(map[int]byte)
+
+	for pos := 0; pos+8 <= len(data); {
+		length := int(f.ByteOrder.Uint32(data[pos:]))
+		if length == 0 {
+			break
+		}
+
+		// 64-bit DWARF isn't used for .eh_frame in practice.
+		if length == 0xFFFFFFFF || pos+4+length > len(data) {
+			break
+		}
+		record := data[pos+4 : pos+4+length]
+		start := pos
+		pos += 4 + length
+
+		id := int(f.ByteOrder.Uint32(record))
+		if id == 0 {
+			encodings[start] = cieEncoding(record[4:], ptrsize)
+			continue
+		}
+
+		// The CIE pointer is relative to its own field.
+		encoding, ok := encodings[start+4-id]
+		if !ok {
+			continue
+		}
+
+		r := &pointerReader{
+			data:    record,
+			pos:     4,
+			addr:    int(s.Addr) + start + 4,
+			ptrsize: ptrsize,
+			order:   f.ByteOrder,
+		}
+		begin, ok1 := r.read(encoding)
+		size, ok2 := r.read(encoding & 0x0F)
+		if !ok1 || !ok2 || begin == 0 {
+			continue
+		}
+
+		img.Unwind = append(img.Unwind, Range{begin, begin + size})
+	}
+
+	sort.Slice(img.Unwind, func(i, j int) bool { return img.Unwind[i].Start < img.Unwind[j].Start })
+}
+
+// FDE pointer encoding of a CIE, from the body after its ID. Only the 'R'
+// entry of the augmentation data matters, and without it pointers are
+// absolute.
+func cieEncoding(cie []byte, ptrsize int) byte {
+	if len(cie) < 2 {
+		return DW_EH_PE_absptr
+	}
+
+	augmentation := cie[1:]
+	end := 0
+	for end < len(augmentation) && augmentation[end] != 0 {
+		end++
+	}
+	if end == len(augmentation) || end == 0 || augmentation[0] != 'z' {
+		return DW_EH_PE_absptr
+	}
+	aug := string(augmentation[:end])
+	rest := augmentation[end+1:]
+
+	r := &pointerReader{data: rest, ptrsize: ptrsize}
+
+	// Code alignment, data alignment and return address register. The
+	// register is a byte in version 1 and a ULEB128 after.
+	r.uleb()
+	r.sleb()
+	if cie[0] == 1 {
+		r.pos++
+	} else {
+		r.uleb()
+	}
+	r.uleb()
+
+	for _, c := range aug[1:] {
+		if r.pos >= len(r.data) {
+			break
+		}
+		switch c {
+		case 'R':
+			return r.data[r.pos]
+		case 'P':
+			encoding := r.data[r.pos]
+			r.pos++
+			r.read(encoding)
+		case 'L':
+			r.pos++
+		}
+	}
+
+	return DW_EH_PE_absptr
+}
+
+// Reader of encoded pointers in an .eh_frame record, at address addr.
+type pointerReader struct {
+	data    []byte
+	pos     int
+	addr    int
+	ptrsize int
+	order   binary.ByteOrder
+}
+
+func (r *pointerReader) uleb() int {
+	var value, shift uint
+	for r.pos < len(r.data) {
+		b := r.data[r.pos]
+		r.pos++
+		value |= uint(b&0x7F) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return int(value)
+}
+
+func (r *pointerReader) sleb() int {
+	var value int
+	var shift uint
+	var b byte
+	for r.pos < len(r.data) {
+		b = r.data[r.pos]
+		r.pos++
+		value |= int(b&0x7F) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if shift < 64 && b&0x40 != 0 {
+		value |= -1 << shift
+	}
+	return value
+}
+
+// Read a pointer in the given encoding, returning false if it runs off the
+// end of the record or the encoding isn't understood.
+func (r *pointerReader) read(encoding byte) (int, bool) {
+	if encoding == DW_EH_PE_omit {
+		return 0, false
+	}
+	if r.order == nil {
+		r.order = binary.LittleEndian
+	}
+
+	field := r.addr + r.pos
+	format := encoding & 0x0F
+	if format == DW_EH_PE_absptr {
+		format = DW_EH_PE_udata4
+		if r.ptrsize == 8 {
+			format = DW_EH_PE_udata8
+		}
+	}
+
+	sizes := map[byte]int{
+		DW_EH_PE_udata2: 2, DW_EH_PE_sdata2: 2,
+		DW_EH_PE_udata4: 4, DW_EH_PE_sdata4: 4,
+		DW_EH_PE_udata8: 8, DW_EH_PE_sdata8: 8,
+	}
+
+	var value int
+	switch format {
+	case DW_EH_PE_uleb128:
+		value = r.uleb()
+	case DW_EH_PE_sleb128:
+		value = r.sleb()
+	default:
+		size, ok := sizes[format]
+		if !ok || r.pos+size > len(r.data) {
+			return 0, false
+		}
+		b := r.data[r.pos:]
+		switch format {
+		case DW_EH_PE_udata2:
+			value = int(r.order.Uint16(b))
+		case DW_EH_PE_sdata2:
+			value = int(int16(r.order.Uint16(b)))
+		case DW_EH_PE_udata4:
+			value = int(r.order.Uint32(b))
+		case DW_EH_PE_sdata4:
+			value = int(int32(r.order.Uint32(b)))
+		default:
+			value = int(r.order.Uint64(b))
+		}
+		r.pos += size
+	}
+
+	if encoding&0x70 == DW_EH_PE_pcrel {
+		value += field
+	}
+
+	return value, true
+}
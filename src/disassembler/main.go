@@ -2,137 +2,1194 @@ package main
 
 import (
 	"bytes"
+	"disassembler/analysis"
 	"disassembler/datatypes"
+	"disassembler/loaders"
 	"disassembler/operations"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 )
 
 // Global maps
 var Instructions = make(map[int]*datatypes.Instruction)
+var Markers = make(map[int]string)   // Comments printed beside instructions, by offset.
+var Headers = make(map[int][]string) // Comment lines printed above instructions, by offset.
 
-// Command line argument
+// The loaded image
+var Image *loaders.Image
+
+// Control flow graph and functions of the decoded instructions.
+var Graph *analysis.CFG
+var Functions []*analysis.Function
+var Xrefs *analysis.Xrefs
+var Liveness *analysis.Liveness
+var Frames []*analysis.Frame
+
+// Jump tables of switches, by address, with their number of entries.
+var Tables = make(map[int]int)
+
+// Cases listed on each line of a jump table, by offset. They're named when
+// printed, as functions found after the table is listed may rename them.
+var Entries = make(map[int][]int)
+
+// Longest an instruction can be
+const MAX_INSTRUCTION = 15
+
+// Operand size of the code being decoded.
+var Bits = datatypes.BITS_32
+
+// Command line arguments
 var infile string
+var arch string
+var base, skip, length int
+var start, end int
+var pid int
+var mapsel string
+var around int
+var symfiles string
+var source bool
+var recursive bool
+var hybrid bool
+var classify bool
+var datasecs bool
+var regions bool
+var cfg bool
+var format string
+var functions bool
+var names bool
+var xrefs bool
+var callgraph bool
+var callers string
+var paths string
+var from string
+var live bool
+var defuse bool
+var stack bool
+var conventions bool
+var displacement string
+var pad bool
 
 func init() {
 
-	flag.StringVar(&infile, "i", "", "File to disassemble.")
+	flag.StringVar(&infile, "i", "", "File to disassemble, or - for stdin.")
+	flag.StringVar(&arch, "arch", "", "Slice to disassemble from a fat binary. Only x86 can be decoded.")
+	flag.IntVar(&base, "base", 0, "Load address of a raw file.")
+	flag.IntVar(&skip, "skip", 0, "File offset of a raw file to start disassembling from.")
+	flag.IntVar(&skip, "offset", 0, "Same as -skip.")
+	flag.IntVar(&length, "length", 0, "Number of bytes of a raw file to disassemble.")
+	flag.IntVar(&start, "start", 0, "Virtual address to start disassembling from.")
+	flag.IntVar(&end, "end", 0, "Virtual address to stop disassembling at.")
+	flag.IntVar(&pid, "pid", 0, "Live process to disassemble from /proc/<pid>/mem.")
+	flag.StringVar(&mapsel, "maps", "", "Mapping of -pid to disassemble, by address or path. Defaults to the main executable.")
+	flag.IntVar(&around, "around", 0x40, "Bytes to disassemble either side of the fault in a crash dump.")
+	flag.StringVar(&symfiles, "syms", "", "Comma-separated nm output or linker map files to load symbols from.")
+	flag.BoolVar(&recursive, "recursive", false, "Follow branches from the entry point and symbols instead of decoding every byte.")
+	flag.BoolVar(&hybrid, "hybrid", false, "Follow branches as -recursive does, then sort what's left into code and data.")
+	flag.BoolVar(&datasecs, "data", false, "List the data sections too, with their strings, pointer arrays and padding picked out. In code, -recursive, -hybrid and -classify pick them out of what isn't decoded.")
+	flag.BoolVar(&classify, "classify", false, "Score each region of the code sections as code or data, and list the data as such.")
+	flag.BoolVar(&regions, "map", false, "Print a map of the regions -classify scores as code or data, with its confidence in each, instead of the listing.")
+	flag.BoolVar(&cfg, "cfg", false, "Print the basic blocks and their successors instead of the listing.")
+	flag.StringVar(&format, "format", "text", "Output format: text, or dot or mermaid for a control flow graph of each function. With -callgraph: text, dot or json.")
+	flag.BoolVar(&functions, "functions", false, "List the functions found, with their start, end and size, instead of the listing.")
+	flag.BoolVar(&names, "names", false, "Label the functions found that have no name sub_XXXXXXXX in the listing, and name them so in calls to them.")
+	flag.BoolVar(&xrefs, "xrefs", false, "Comment above each line of the listing the instructions that refer to it.")
+	flag.BoolVar(&callgraph, "callgraph", false, "Print the call graph instead of the listing, as text, or with -format dot or json.")
+	flag.StringVar(&callers, "callers", "", "List the calls to a function, by name or address.")
+	flag.StringVar(&paths, "paths", "", "List the chains of calls from the entry point to a function, by name or address.")
+	flag.StringVar(&from, "from", "", "Function for -paths to start from instead of the entry point. Without either, paths start from every function nothing calls.")
+	flag.BoolVar(&live, "live", false, "Show the registers live before each instruction, and those it writes that are never read.")
+	flag.BoolVar(&defuse, "defuse", false, "List each register definition with the instructions that read it, instead of the listing.")
+	flag.BoolVar(&conventions, "conventions", false, "Infer each function's calling convention and number of arguments, and comment on them above it.")
+	flag.StringVar(&displacement, "disp", "hex", "How to write displacements from registers, as in [ ebp-0x8 ]: hex or dec.")
+	flag.BoolVar(&pad, "pad", false, "Zero-pad displacements from registers to their size, as in [ ebp-0x08 ].")
+	flag.BoolVar(&stack, "stack", false, "Follow the stack pointer through each function, name its arguments and locals, and point out returns that leave the stack unbalanced.")
+	flag.BoolVar(&source, "source", false, "Show the source lines from the DWARF line table above their code.")
 	flag.Parse()
 }
 
 func main() {
 	// Parse instructions from file
-	if infile == "" {
+	if infile == "" && pid == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	var f *os.File
-	var data = new(bytes.Buffer)
-	var err error
+	switch displacement {
+	case "hex":
+	case "dec":
+		datatypes.DispDecimal = true
+	default:
+		log.Fatalf("Error: unknown displacement format %s", displacement)
+	}
+	datatypes.DispPad = pad
+
+	var img *loaders.Image
+	var err error
+
+	if pid != 0 {
+		if img, err = loaders.LoadProcess(pid, mapsel); err != nil {
+			log.Fatalf("Error loading process: %s", err)
+		}
+	} else {
+		var f *os.File
+		var data = new(bytes.Buffer)
+
+		if infile == "-" {
+			f = os.Stdin
+		} else if f, err = os.Open(infile); err != nil {
+			log.Fatalf("Error opening file: %s", err)
+		}
+
+		if err = ReadAll(data, f); err != nil {
+			log.Fatalf("Error reading file: %s", err)
+		}
+
+		opts := loaders.Options{
+			Name:   infile,
+			Arch:   arch,
+			Base:   base,
+			Skip:   skip,
+			Length: length,
+		}
+
+		if img, err = loaders.Load(data.Bytes(), opts); err != nil {
+			log.Fatalf("Error loading file: %s", err)
+		}
+	}
+
+	// The decoder only understands 32-bit and 16-bit x86.
+	if img.Arch == loaders.ARCH_X86_64 {
+		log.Fatalf("Error: can't decode x86-64 code (%s), only x86 and 16-bit x86.", img.Format)
+	}
+
+	if start != 0 || end != 0 {
+		img.Window(start, end)
+	} else if img.Fault != 0 {
+		img.Window(img.Fault-around, img.Fault+around)
+	}
+
+	// Make sure crash dumps decode from each thread's instruction pointer,
+	// and point them out in the listing.
+	for _, thread := range img.Threads {
+		img.Split(thread.PC)
+		Markers[thread.PC] = fmt.Sprintf("; <-- Thread %d", thread.Id)
+	}
+	if img.Fault != 0 {
+		img.Split(img.Fault)
+		Markers[img.Fault] = "; <-- Fault"
+	}
+
+	// Load extra symbols, e.g. from a stripped binary's link map.
+	if symfiles != "" {
+		for _, name := range strings.Split(symfiles, ",") {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				log.Fatalf("Error reading symbols: %s", err)
+			}
+			syms, err := loaders.ParseSymbolFile(data)
+			if err != nil {
+				log.Fatalf("Error reading symbols from %s: %s", name, err)
+			}
+			img.AddSymbols(syms)
+		}
+	}
+
+	// Label every symbol in code, and the entry point if it has none.
+	for _, sym := range img.Symbols {
+		if sec := img.SectionAt(sym.Addr); sec == nil || !sec.Exec {
+			continue
+		}
+		if _, exists := Instructions[sym.Addr]; !exists {
+			Instructions[sym.Addr] = &datatypes.Instruction{
+				Offset: sym.Addr,
+				Label:  sym.Name,
+			}
+		}
+	}
+
+	if _, exists := Instructions[img.Entry]; img.Entry != 0 && !exists {
+		Instructions[img.Entry] = &datatypes.Instruction{
+			Offset: img.Entry,
+			Label:  "entry",
+		}
+	}
+
+	// Label functions from unwind tables too, so they're decoded from the
+	// right place.
+	for _, r := range img.Unwind {
+		if sec := img.SectionAt(r.Start); sec == nil || !sec.Exec {
+			continue
+		}
+		if _, exists := Instructions[r.Start]; !exists {
+			Instructions[r.Start] = &datatypes.Instruction{
+				Offset: r.Start,
+				Label:  fmt.Sprintf("offset_%08xh", r.Start),
+			}
+		}
+	}
+
+	Image = img
+
+	if img.Arch == loaders.ARCH_X86_16 {
+		Bits = datatypes.BITS_16
+	}
+
+	// Tell sections apart when there's more than one, e.g. archive members.
+	code := img.Code()
+	for _, section := range code {
+		if len(code) > 1 {
+			Headers[section.Addr] = append(Headers[section.Addr], "; Section "+section.Name)
+		}
+		if !recursive && !hybrid && !classify && !regions {
+			Parse_Instructions(section.Buffer(), section.Addr)
+		}
+	}
+
+	if regions {
+		Print_Regions(code)
+		return
+	}
+
+	if classify {
+		Parse_Classified(code)
+	} else if hybrid {
+		Parse_Hybrid(code)
+	} else if recursive {
+		Parse_Recursive(code)
+	}
+
+	// Data sections are listed in full, as there's no code among them. Their
+	// symbols are labelled, and the jump tables in them, as in .rodata, are
+	// listed as such first, so the data around them stops where they start.
+	if datasecs {
+		for _, section := range img.Sections {
+			if section.Exec || len(section.Data) == 0 {
+				continue
+			}
+			Headers[section.Addr] = append(Headers[section.Addr], "; Section "+section.Name)
+
+			for _, sym := range img.Symbols {
+				if _, exists := Instructions[sym.Addr]; !exists && img.SectionAt(sym.Addr) == section {
+					Instructions[sym.Addr] = &datatypes.Instruction{
+						Offset: sym.Addr,
+						Label:  sym.Name,
+					}
+				}
+			}
+
+			covered := make(map[int]bool)
+			for table, count := range Tables {
+				if Image.SectionAt(table) != section {
+					continue
+				}
+				Emit_Table(table, count)
+				for i := 0; i < 4*count; i++ {
+					covered[table+i] = true
+				}
+			}
+			Parse_Data(section, covered)
+		}
+	}
+
+	// Outputs other than the listing name every function they show, so
+	// they label them as -names does.
+	outline := cfg || format != "text" || functions || callgraph || callers != "" || paths != ""
+	if outline || names || live || defuse || stack || conventions {
+		Graph = analysis.BuildCFG(Instructions)
+		Functions = Find_Functions(Graph)
+	}
+	if outline || names {
+		Name_Functions(Functions)
+	}
+
+	if xrefs {
+		Xrefs = analysis.BuildXrefs(Instructions, func(addr int) bool { return Image.SectionAt(addr) != nil }, Immediate_Address)
+	}
+
+	if live {
+		Liveness = Graph.Liveness()
+	}
+
+	if stack {
+		if Bits == datatypes.BITS_16 {
+			log.Fatalf("Error: -stack only follows 32-bit code.")
+		}
+		Frames = Analyze_Frames()
+	}
+
+	if conventions {
+		Infer_Conventions()
+	}
+
+	if source {
+		Interleave_Source()
+	}
+
+	if callgraph || callers != "" || paths != "" {
+		Print_Call_Graph(Build_Call_Graph())
+		return
+	}
+
+	switch format {
+	case "text":
+	case "dot", "mermaid":
+		Print_Graphs(Graph, format)
+		return
+	default:
+		log.Fatalf("Error: unknown output format %s", format)
+	}
+
+	if cfg {
+		Print_CFG(Graph)
+		return
+	}
+
+	if functions {
+		Print_Functions()
+		return
+	}
+
+	if defuse {
+		Print_DefUse(Graph)
+		return
+	}
+
+	// Print out each instruction
+	Print_Instructions()
+}
+
+func Parse_Instructions(data *bytes.Buffer, base int) error {
+	var err error
+
+	var offset int = base
+
+	for {
+
+		// List a switch's jump table as data, and carry on after it, even if
+		// the sweep comes into it part way.
+		if table, count, ok := Table_At(offset, offset+1); ok && data.Len() >= table+4*count-offset {
+			data.Next(table + 4*count - offset)
+			Emit_Table(table, count)
+			offset = table + 4*count
+			continue
+		}
+
+		// Don't decode an instruction into the start of a table. Decode from
+		// the bytes before it instead, and list them as they are if they
+		// don't make one. A table that doesn't start among the bytes ahead,
+		// such as one in the next section, is no concern.
+		source := data
+		if table, _, ok := Table_At(offset+1, offset+MAX_INSTRUCTION); ok && table > offset && data.Len() >= table-offset {
+			source = bytes.NewBuffer(data.Bytes()[:table-offset])
+		}
+
+		// Grab the instruction from the master map if it exists, or create a new one.
+		instruction := &datatypes.Instruction{}
+		if inst, exists := Instructions[offset]; exists {
+			instruction = inst
+		}
+
+		var other_offset int
+		var is_offset bool
+
+		if other_offset, is_offset, err = Decode_Instruction(source, offset, instruction); err != nil {
+			if source == data {
+				break
+			}
+			*instruction = datatypes.Instruction{
+				Offset:   offset,
+				Label:    instruction.Label,
+				Mnemonic: fmt.Sprintf("db %02x", data.Bytes()[0]),
+				Literal:  []byte{data.Bytes()[0]},
+			}
+			err = nil
+		}
+		if source != data {
+			data.Next(len(instruction.Literal))
+		}
+
+		// Add labels to other instructions if instruction has an offset as an operand.
+		if is_offset {
+			Label_Target(instruction, other_offset)
+		}
+
+		// A switch's table behind the sweep has been decoded already.
+		if table, ok := Resolve_Switch(instruction); ok && table < offset {
+			if section := Image.SectionAt(table); section != nil && section.Exec {
+				Emit_Table(table, Tables[table])
+			}
+		}
+
+		// Save the instruction to the master map
+		Instructions[offset] = instruction
+
+		// Update the offset for the next instruction
+		offset += len(instruction.Literal)
+
+	}
+
+	return err
+}
+
+// Decode the instruction at the front of data into instruction, which is at
+// offset. Returns the instruction's branch target, if it has one, or an
+// error at the end of the data.
+func Decode_Instruction(data *bytes.Buffer, offset int, instruction *datatypes.Instruction) (int, bool, error) {
+	var opcode *operations.OpCode
+	var prefix *datatypes.Prefix
+	var opcode_literal byte
+
+	var err error
+
+	// Consume the next opcode from the input.
+	opcode, prefix, opcode_literal, err = operations.GetNext(data)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, false, err
+		}
+
+		// Handle unknown OpCode
+		instruction.Offset = offset
+		instruction.Mnemonic = err.Error()
+		instruction.Literal = append(instruction.Literal, opcode_literal)
+		return 0, false, nil
+	}
+
+	// Start building the instruction
+	instruction.Offset = offset
+	instruction.Pre = prefix
+	instruction.Op = opcode_literal
+	instruction.Bits = Bits
+
+	if prefix != nil {
+		instruction.Literal = append(instruction.Literal, prefix.Literal)
+	}
+	instruction.Literal = append(instruction.Literal, opcode_literal)
+
+	if err = opcode.Encode(data, instruction); err != nil {
+		// The data ends part way through the instruction.
+		if err == io.ErrUnexpectedEOF {
+			return 0, false, err
+		}
+
+		// This should basically never happen.
+		fmt.Printf("Error encoding: %s\n", err)
+	}
+
+	var other_offset int
+	var is_offset bool
+
+	if instruction.Operands, other_offset, is_offset, err = opcode.Encoder.StringifyOperands(instruction); err != nil {
+		// This also shouldn't really happen.
+		fmt.Printf("Error stringifying: %s\n", err)
+	}
+	is_offset = Relocate_Operands(instruction, is_offset)
+	Symbolize_Memory(instruction)
+
+	instruction.Target = other_offset
+	instruction.HasTarget = is_offset
+
+	// Name the target after the symbol covering it, if any.
+	if is_offset {
+		if name := Image.SymbolName(other_offset); name != "" {
+			instruction.Operands = name
+		}
+	}
+
+	return other_offset, is_offset, nil
+}
+
+// Label the target of instruction's branch, or if it already has a label,
+// use that as instruction's operand, so operands and labels agree.
+func Label_Target(instruction *datatypes.Instruction, other_offset int) {
+	other_instruction := &datatypes.Instruction{
+		Offset: other_offset,
+	}
+	if other_inst, exists := Instructions[other_offset]; exists {
+		other_instruction = other_inst
+	}
+	if other_offset == instruction.Offset {
+		other_instruction = instruction
+	}
+
+	if other_instruction.Label == "" {
+		other_instruction.Label = instruction.Operands
+	} else {
+		instruction.Operands = other_instruction.Label
+	}
+	Instructions[other_offset] = other_instruction
+}
+
+// Jump table overlapping [start, end), with its number of entries, if any.
+// If more than one does, the first.
+func Table_At(start int, end int) (int, int, bool) {
+	found, found_count, ok := 0, 0, false
+	for table, count := range Tables {
+		if table < end && table+4*count > start && (!ok || table < found) {
+			found, found_count, ok = table, count, true
+		}
+	}
+	return found, found_count, ok
+}
+
+// Most entries read from a jump table.
+const MAX_CASES = 1024
+
+// If instruction is a switch's indirect jump through a table of addresses,
+// "jmp [idx*4+table]", read its table and label and record the cases. The
+// bounds check before the jump, "cmp idx, n; ja default", says how many
+// there are, and without one the table runs as long as its entries point
+// into code. Returns the table's address.
+func Resolve_Switch(instruction *datatypes.Instruction) (int, bool) {
+	modrm := instruction.Modrm
+	if !analysis.IsJump(instruction) || modrm == nil || modrm.Sib == nil || modrm.Mod != datatypes.AM_REG {
+		return 0, false
+	}
+	if modrm.Sib.Base != datatypes.REG_EBP || modrm.Sib.Index == datatypes.REG_ESP || modrm.Sib.Scale != 4 {
+		return 0, false
+	}
+
+	table, err := datatypes.BytesToInt(instruction.Displacement)
+	if err != nil {
+		return 0, false
+	}
+
+	count := Switch_Bound(instruction)
+	bounded := count > 0
+	if !bounded || count > MAX_CASES {
+		count = MAX_CASES
+	}
+
+	var cases []int
+	for i := 0; i < count; i++ {
+		entry, err := Image.Read(table+4*i, 4)
+		if err != nil {
+			break
+		}
+		target, _ := datatypes.BytesToInt(entry)
+		if section := Image.SectionAt(target); section == nil || !section.Exec {
+			break
+		}
+
+		// Without a bound, a label means something else starts here.
+		if inst, exists := Instructions[table+4*i]; !bounded && i > 0 && exists && inst.Label != "" {
+			break
+		}
+		cases = append(cases, target)
+	}
+	if len(cases) == 0 {
+		return 0, false
+	}
+
+	for _, target := range cases {
+		other_instruction, exists := Instructions[target]
+		if !exists {
+			other_instruction = &datatypes.Instruction{
+				Offset: target,
+			}
+			Instructions[target] = other_instruction
+		}
+		if other_instruction.Label == "" {
+			other_instruction.Label = fmt.Sprintf("case_%08x", target)
+		}
+	}
+
+	instruction.Cases = cases
+	Tables[table] = len(cases)
+
+	if _, exists := Markers[instruction.Offset]; !exists {
+		Markers[instruction.Offset] = fmt.Sprintf("; switch, %d cases at 0x%08x", len(cases), table)
+	}
+
+	return table, true
+}
+
+// Number of cases the bounds check before a switch's jump allows, "cmp idx,
+// n" then "ja default", or 0 if there isn't one in the few instructions
+// leading up to it.
+func Switch_Bound(instruction *datatypes.Instruction) int {
+	offset := instruction.Offset
+	for i := 0; i < 8; i++ {
+		prev := Containing_Instruction(offset - 1)
+		if len(prev.Literal) == 0 || prev.Offset+len(prev.Literal) != offset || !analysis.IsCode(prev) {
+			return 0
+		}
+
+		if prev.Mnemonic == "ja" {
+			cmp := Containing_Instruction(prev.Offset - 1)
+			if cmp.Mnemonic != "cmp" || cmp.Offset+len(cmp.Literal) != prev.Offset || len(cmp.Immediate) == 0 {
+				return 0
+			}
+			if cmp.Modrm != nil && cmp.Modrm.Mod != datatypes.AM_DIRECT {
+				return 0
+			}
+			bound, err := datatypes.BytesToIntSigned(cmp.Immediate)
+			if err != nil || bound < 0 {
+				return 0
+			}
+			return bound + 1
+		}
+
+		offset = prev.Offset
+	}
+	return 0
+}
+
+// List the jump table of count entries at table as "dd" lines of up to 4
+// cases each, in place of anything decoded over it.
+func Emit_Table(table int, count int) {
+	label := ""
+	for offset := table - MAX_INSTRUCTION; offset < table+4*count; offset++ {
+		if inst, exists := Instructions[offset]; exists && len(inst.Literal) > 0 && offset+len(inst.Literal) > table {
+			if offset == table {
+				label = inst.Label
+			}
+			delete(Instructions, offset)
+		}
+	}
+
+	Headers[table] = append(Headers[table], fmt.Sprintf("; Jump table, %d cases", count))
+
+	for i := 0; i < count; i += 4 {
+		offset := table + 4*i
+		instruction := &datatypes.Instruction{
+			Offset:   offset,
+			Mnemonic: "dd",
+		}
+		if inst, exists := Instructions[offset]; exists {
+			instruction = inst
+			instruction.Mnemonic = "dd"
+			instruction.Literal = nil
+		} else if i == 0 {
+			instruction.Label = label
+		}
+
+		var targets []int
+		for j := i; j < count && j < i+4; j++ {
+			entry, _ := Image.Read(table+4*j, 4)
+			target, _ := datatypes.BytesToInt(entry)
+			instruction.Literal = append(instruction.Literal, entry...)
+			targets = append(targets, target)
+		}
+
+		Entries[offset] = targets
+		Instructions[offset] = instruction
+	}
+}
+
+// Disassemble by recursive descent: decode from each entry point, symbol and
+// thread, following branch and call targets, and stop each path at a return,
+// an unconditional jump or an undecodable byte. Code that's never reached is
+// listed as data, rather than decoded as garbage that throws the following
+// instructions out of step.
+func Parse_Recursive(code []*loaders.Section) {
+	covered := Traverse(Roots(code), make(map[int]bool))
+
+	for _, section := range code {
+		Parse_Data(section, covered)
+	}
+}
+
+// Decode by recursive descent from each of the pending offsets, marking the
+// bytes of each instruction decoded as covered.
+func Traverse(pending []int, covered map[int]bool) map[int]bool {
+	// Decode the lowest address first, as a linear sweep would.
+	sort.Sort(sort.Reverse(sort.IntSlice(pending)))
+
+	for len(pending) > 0 {
+		offset := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		for {
+			section := Image.SectionAt(offset)
+			if section == nil || !section.Exec || covered[offset] {
+				break
+			}
+
+			instruction := &datatypes.Instruction{}
+			if inst, exists := Instructions[offset]; exists {
+				instruction = inst
+			}
+
+			data := bytes.NewBuffer(section.Data[offset-section.Addr:])
+			other_offset, is_offset, err := Decode_Instruction(data, offset, instruction)
+			if err != nil {
+				break
+			}
+
+			Instructions[offset] = instruction
+			for i := range instruction.Literal {
+				covered[offset+i] = true
+			}
+
+			if is_offset {
+				Label_Target(instruction, other_offset)
+				pending = append(pending, other_offset)
+			}
+
+			// Carry on from each case of a switch, and keep its table from
+			// being decoded.
+			if table, ok := Resolve_Switch(instruction); ok {
+				pending = append(pending, instruction.Cases...)
+				if section := Image.SectionAt(table); section != nil && section.Exec {
+					Emit_Table(table, Tables[table])
+					for i := 0; i < 4*Tables[table]; i++ {
+						covered[table+i] = true
+					}
+				}
+			}
+
+			if Is_Unknown(instruction) || Is_Terminator(instruction) {
+				break
+			}
+
+			offset += len(instruction.Literal)
+		}
+	}
+
+	return covered
+}
+
+// Disassemble by recursive descent first, then look at what it didn't reach:
+// gaps that look like code, by their prologue or by decoding cleanly, are
+// traversed in turn, and the rest are listed as data. Where a linear sweep
+// would have decoded the code differently, the listing says so.
+func Parse_Hybrid(code []*loaders.Section) {
+	covered := Traverse(Roots(code), make(map[int]bool))
+	decided := make(map[int]bool)
+
+	for progress := true; progress; {
+		progress = false
+
+		for _, section := range code {
+			end := section.Addr + len(section.Data)
+
+			for offset := section.Addr; offset < end; offset++ {
+				if covered[offset] || decided[offset] {
+					continue
+				}
+
+				gap_end := offset
+				for gap_end < end && !covered[gap_end] && !decided[gap_end] {
+					gap_end++
+				}
+
+				// Padding before a function is a gap of its own.
+				gap := section.Data[offset-section.Addr : gap_end-section.Addr]
+				if n := Padding_Length(gap); n > 0 {
+					gap_end = offset + n
+				}
+
+				is_code, reason := Classify_Gap(section, offset, gap_end)
+				if is_code {
+					covered = Traverse([]int{offset}, covered)
+					progress = true
+				}
+
+				// Anything undecodable right at the start is data after all.
+				if !covered[offset] {
+					is_code = false
+				}
+
+				kind := "data"
+				if is_code {
+					kind = "code"
+				}
+				Headers[offset] = append(Headers[offset], fmt.Sprintf("; Unreached %s: %s", kind, reason))
+
+				if !is_code {
+					for i := offset; i < gap_end; i++ {
+						decided[i] = true
+					}
+				}
+				offset = gap_end - 1
+			}
+		}
+	}
+
+	for _, section := range code {
+		Parse_Data(section, covered)
+		Report_Conflicts(section)
+	}
+}
+
+// Offsets to start recursive descent from: labels, which come from the entry
+// point and symbols, and crashed threads. Without any of those in a section,
+// as in a raw file, start from the top of the section.
+func Roots(code []*loaders.Section) []int {
+	var pending []int
+
+	for offset := range Instructions {
+		pending = append(pending, offset)
+	}
+	for offset := range Markers {
+		pending = append(pending, offset)
+	}
+
+	for _, section := range code {
+		found := false
+		for _, offset := range pending {
+			if Image.SectionAt(offset) == section {
+				found = true
+				break
+			}
+		}
+		if !found {
+			pending = append(pending, section.Addr)
+		}
+	}
+
+	return pending
+}
+
+// Common function prologues
+var Prologues = [][]byte{
+	{0x55, 0x89, 0xE5},             // push ebp; mov ebp, esp
+	{0x55, 0x8B, 0xEC},             // push ebp; mov ebp, esp
+	{0x8B, 0xFF, 0x55, 0x8B, 0xEC}, // mov edi, edi; push ebp; mov ebp, esp
+	{0xF3, 0x0F, 0x1E, 0xFB},       // endbr32
+	{0xF3, 0x0F, 0x1E, 0xFA},       // endbr64
+}
+
+// Fraction of undecodable instructions above which a gap is taken for data.
+const INVALID_DENSITY = 0.25
+
+// Guess whether the bytes of section from start to end are code or data, and
+// say why.
+func Classify_Gap(section *loaders.Section, start, end int) (bool, string) {
+	gap := section.Data[start-section.Addr : end-section.Addr]
+
+	if Padding_Length(gap) == len(gap) {
+		return false, "padding"
+	}
+
+	for _, prologue := range Prologues {
+		if bytes.HasPrefix(gap, prologue) {
+			return true, "function prologue"
+		}
+	}
+
+	// Decode the gap on its own, without touching the listing. Running into
+	// the code after the gap counts against it.
+	var total, unknown int
+	data := bytes.NewBuffer(section.Data[start-section.Addr:])
+	for offset := start; offset < end; {
+		instruction := &datatypes.Instruction{}
+		_, _, err := Decode_Instruction(data, offset, instruction)
+		overrun := len(instruction.Literal) == 0 || offset+len(instruction.Literal) > end
+
+		// Each instruction counts once, whether it's unknown, overruns, or both.
+		total++
+		if err != nil || Is_Unknown(instruction) || overrun {
+			unknown++
+		}
+		if overrun {
+			break
+		}
+		offset += len(instruction.Literal)
+	}
+
+	density := float64(unknown) / float64(total)
+	reason := fmt.Sprintf("%d%% invalid opcodes", int(density*100))
+
+	return density <= INVALID_DENSITY, reason
+}
+
+// Score the regions of section as code or data.
+func Classify_Regions(section *loaders.Section) []*analysis.Region {
+	decode := func(data []byte, offset int) *datatypes.Instruction {
+		instruction := &datatypes.Instruction{}
+		if _, _, err := Decode_Instruction(bytes.NewBuffer(data), offset, instruction); err != nil {
+			return nil
+		}
+		return instruction
+	}
+
+	return analysis.ClassifyRegions(section.Data, section.Addr, decode)
+}
+
+// Decode the regions of each section that score as code by linear sweep,
+// and list the rest as data, each headed by its score.
+func Parse_Classified(code []*loaders.Section) {
+	for _, section := range code {
+		covered := make(map[int]bool)
+
+		for _, region := range Classify_Regions(section) {
+			kind := "data"
+			if region.Code {
+				kind = "code"
+				data := section.Data[region.Start-section.Addr : region.End-section.Addr]
+				Parse_Instructions(bytes.NewBuffer(data), region.Start)
+
+				for offset := region.Start; offset < region.End; offset++ {
+					if inst, exists := Instructions[offset]; exists {
+						for i := range inst.Literal {
+							covered[offset+i] = true
+						}
+					}
+				}
+			}
+			Headers[region.Start] = append(Headers[region.Start],
+				fmt.Sprintf("; Region of %s, %d%% confidence", kind, int(region.Confidence*100)))
+		}
+
+		Parse_Data(section, covered)
+	}
+}
+
+// Print the regions of each section scored as code or data, with the
+// confidence in each score and what it's made of.
+func Print_Regions(code []*loaders.Section) {
+	t := new(tabwriter.Writer)
+	t.Init(os.Stdout, 8, 8, 1, ' ', 0)
+	defer t.Flush()
+
+	fmt.Fprintf(t, "Start\tEnd\tSize\tKind\tConfidence\tEntropy\tInvalid\tCommon\tSection\n")
+	for _, section := range code {
+		for _, region := range Classify_Regions(section) {
+			kind := "data"
+			if region.Code {
+				kind = "code"
+			}
+			fmt.Fprintf(t, "%08x\t%08x\t%d\t%s\t%d%%\t%.2f\t%d%%\t%d%%\t%s\n",
+				region.Start, region.End, region.End-region.Start, kind, int(region.Confidence*100),
+				region.Entropy, int(region.Invalid*100), int(region.Common*100), section.Name)
+		}
+	}
+}
+
+// Number of padding bytes, nop, int3 or zero, at the start of data.
+func Padding_Length(data []byte) int {
+	for i, b := range data {
+		if b != 0x00 && b != 0x90 && b != 0xCC {
+			return i
+		}
+	}
+	return len(data)
+}
+
+// Point out where a linear sweep of section would have decoded instructions
+// that overlap the ones in the listing, up to where the two get back in step.
+func Report_Conflicts(section *loaders.Section) {
+	data := section.Buffer()
+	end := section.Addr + len(section.Data)
+
+	conflict := -1
+	for offset := section.Addr; offset < end; {
+		instruction := &datatypes.Instruction{}
+		if _, _, err := Decode_Instruction(data, offset, instruction); err != nil {
+			break
+		}
+
+		inst, exists := Instructions[offset]
+		in_step := exists && len(inst.Literal) > 0
 
-	if f, err = os.Open(infile); err != nil {
-		log.Fatalf("Error opening file: %s", err)
-	}
+		switch {
+		case in_step && conflict >= 0:
+			Headers[conflict] = append(Headers[conflict],
+				fmt.Sprintf("; Conflict: linear sweep decodes differently until 0x%08x", offset))
+			conflict = -1
+		case !in_step && conflict < 0 && !Is_Data(Containing_Instruction(offset)):
+			conflict = Containing_Instruction(offset).Offset
+		}
 
-	if err = ReadAll(data, f); err != nil {
-		log.Fatalf("Error reading file: %s", err)
+		offset += len(instruction.Literal)
 	}
+}
 
-	Parse_Instructions(data)
+// Instruction in the listing covering offset, if any, or an empty one.
+func Containing_Instruction(offset int) *datatypes.Instruction {
+	for start := offset; start > offset-16; start-- {
+		if inst, exists := Instructions[start]; exists && start+len(inst.Literal) > offset {
+			return inst
+		}
+	}
+	return &datatypes.Instruction{Offset: offset}
+}
 
-	// Print out each instruction
-	Print_Instructions()
+// Is instruction an opcode the decoder doesn't know, listed as a byte?
+func Is_Unknown(instruction *datatypes.Instruction) bool {
+	return instruction.Op == 0 && strings.HasPrefix(instruction.Mnemonic, "db ")
 }
 
-func Parse_Instructions(data *bytes.Buffer) error {
-	var opcode *operations.OpCode
-	var prefix *datatypes.Prefix
-	var opcode_literal byte
+// Is instruction a line of data rather than code?
+func Is_Data(instruction *datatypes.Instruction) bool {
+	return analysis.Directives[instruction.Mnemonic] || len(instruction.Literal) == 0
+}
 
-	var err error
+// Does control never fall through instruction to the next one?
+func Is_Terminator(instruction *datatypes.Instruction) bool {
+	return analysis.IsJump(instruction) || analysis.IsReturn(instruction)
+}
 
-	var offset int = 0
+// Size of a pointer in the image's data, x86 being all that's decoded.
+const POINTER_SIZE = 4
 
-	for {
+// List the bytes of section that aren't covered by any instruction as data,
+// starting afresh at each label: strings, pointer arrays and padding as
+// such, and anything else 8 bytes a line.
+func Parse_Data(section *loaders.Section, covered map[int]bool) {
+	end := section.Addr + len(section.Data)
+	mapped := func(addr int) bool { return Image.SectionAt(addr) != nil }
 
-		// Grab the instruction from the master map if it exists, or create a new one.
-		instruction := &datatypes.Instruction{}
-		if inst, exists := Instructions[offset]; exists {
-			instruction = inst
+	for offset := section.Addr; offset < end; {
+		if covered[offset] {
+			offset++
+			continue
 		}
 
-		// Consume the next opcode from the input.
-		opcode, prefix, opcode_literal, err = operations.GetNext(data)
-		if err != nil {
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
+		run_end := offset + 1
+		for run_end < end && !covered[run_end] {
+			if _, exists := Instructions[run_end]; exists {
 				break
 			}
+			run_end++
+		}
 
-			// Handle unknown OpCode
-			instruction.Offset = offset
-			instruction.Mnemonic = err.Error()
-			instruction.Literal = append(instruction.Literal, opcode_literal)
-			Instructions[offset] = instruction
-			offset += 1
-			continue
+		run := section.Data[offset-section.Addr : run_end-section.Addr]
+		for _, item := range analysis.ClassifyData(run, offset, POINTER_SIZE, mapped) {
+			Emit_Data(item)
 		}
+		offset = run_end
+	}
+}
 
-		// Start building the instruction
+// Add a run of data to the listing, as one or more directives.
+func Emit_Data(item *analysis.Data) {
+	emit := func(offset int, literal []byte, mnemonic string, operands string) {
+		instruction := &datatypes.Instruction{}
+		if inst, exists := Instructions[offset]; exists {
+			instruction = inst
+		}
 		instruction.Offset = offset
-		instruction.Pre = prefix
-		instruction.Op = opcode_literal
+		instruction.Literal = literal
+		instruction.Mnemonic = mnemonic
+		instruction.Operands = operands
+		Instructions[offset] = instruction
+	}
 
-		if prefix != nil {
-			instruction.Literal = append(instruction.Literal, prefix.Literal)
+	switch item.Kind {
+	case analysis.DATA_ASCII, analysis.DATA_UTF16:
+		mnemonic := "db"
+		if item.Kind == analysis.DATA_UTF16 {
+			mnemonic = "dw"
 		}
-		instruction.Literal = append(instruction.Literal, opcode_literal)
-
-		if err = opcode.Encode(data, instruction); err != nil {
-			// This should basically never happen.
-			fmt.Printf("Error encoding: %s\n", err)
+		operands := strconv.Quote(item.Text)
+		if item.Terminated {
+			operands += ", 0"
 		}
+		emit(item.Offset, item.Bytes, mnemonic, operands)
 
-		// Add labels to other instructions if instruction has an offset as an operand.
-		var other_offset int
-		var is_offset bool
+	case analysis.DATA_POINTERS:
+		size := len(item.Bytes) / len(item.Pointers)
+		for i := 0; i < len(item.Pointers); i += 4 {
+			var names []string
+			for j := i; j < len(item.Pointers) && j < i+4; j++ {
+				names = append(names, Address_Name(item.Pointers[j]))
+			}
+			last := i + 4
+			if last > len(item.Pointers) {
+				last = len(item.Pointers)
+			}
+			emit(item.Offset+i*size, item.Bytes[i*size:last*size], "dd", strings.Join(names, ", "))
+		}
 
-		if instruction.Operands, other_offset, is_offset, err = opcode.Encoder.StringifyOperands(instruction); err != nil {
-			// This also shouldn't really happen.
-			fmt.Printf("Error stringifying: %s\n", err)
+	case analysis.DATA_PADDING:
+		// Padding up to a boundary is alignment.
+		size := len(item.Bytes)
+		end := item.Offset + size
+		switch {
+		case end%16 == 0 && size < 16:
+			emit(item.Offset, item.Bytes, "align", "16")
+		case end%4 == 0 && size < 4:
+			emit(item.Offset, item.Bytes, "align", "4")
+		default:
+			emit(item.Offset, item.Bytes, "db", fmt.Sprintf("%d dup (0x%02x)", size, item.Bytes[0]))
 		}
-		if is_offset {
-			other_instruction := &datatypes.Instruction{
-				Offset: other_offset,
+
+	default:
+		for i := 0; i < len(item.Bytes); i += 8 {
+			line := item.Bytes[i:]
+			if len(line) > 8 {
+				line = line[:8]
 			}
-			if other_inst, exists := Instructions[other_offset]; exists {
-				other_instruction = other_inst
+			var bytes_literal []string
+			for _, b := range line {
+				bytes_literal = append(bytes_literal, fmt.Sprintf("%02x", b))
 			}
-			other_instruction.Label = instruction.Operands
-			Instructions[other_offset] = other_instruction
+			emit(item.Offset+i, line, "db", strings.Join(bytes_literal, " "))
 		}
+	}
+}
 
-		// Save the instruction to the master map
-		Instructions[offset] = instruction
+// Name of an address: the label there, the symbol covering it, or the
+// address in hex.
+func Address_Name(addr int) string {
+	if inst, exists := Instructions[addr]; exists && inst.Label != "" {
+		return inst.Label
+	}
+	if name := Image.SymbolName(addr); name != "" {
+		return name
+	}
+	return datatypes.StringifyInteger(addr)
+}
 
-		// Update the offset for the next instruction
-		offset += len(instruction.Literal)
+// Longest string quoted in a comment
+const MAX_STRING_COMMENT = 60
 
+// Comment quoting the string an instruction's immediate or absolute memory
+// operand points at, if any, e.g. ; "Hello, world!".
+func String_Comment(instruction *datatypes.Instruction) string {
+	if !analysis.IsCode(instruction) {
+		return ""
 	}
 
-	return err
+	// Only immediates as wide as an address can be one.
+	var addrs []int
+	if size := len(instruction.Immediate); size == 4 || (size == 2 && instruction.Bits == datatypes.BITS_16) {
+		if addr, err := datatypes.BytesToInt(instruction.Immediate); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	if addr, ok := analysis.MemoryAddress(instruction); ok {
+		addrs = append(addrs, addr)
+	}
+
+	for _, addr := range addrs {
+		section := Image.SectionAt(addr)
+		if addr == 0 || section == nil {
+			continue
+		}
+
+		data := section.Data[addr-section.Addr:]
+		if len(data) > 1024 {
+			data = data[:1024]
+		}
+		if text, size, _ := analysis.StringAt(data); size > 0 {
+			if len(text) > MAX_STRING_COMMENT {
+				text = text[:MAX_STRING_COMMENT] + "..."
+			}
+			return "; " + strconv.Quote(text)
+		}
+	}
+	return ""
 }
 
+// Most bytes of an instruction or data listed
+const MAX_LITERAL = 16
+
 func Print_Instructions() {
 
 	// Sort the Instructions map by offset.
-	offsets := make([]int, len(Instructions))
+	offsets := make([]int, 0, len(Instructions))
 
 	for i := range Instructions {
 		offsets = append(offsets, i)
@@ -155,28 +1212,37 @@ func Print_Instructions() {
 			continue
 		}
 
+		// Comments above the line aren't cells, so long ones don't widen
+		// the offset column. They're written past the tabwriter once what's
+		// above is flushed, and the lines after them are aligned afresh.
+		var above []string
+		above = append(above, Headers[offset]...)
+
+		// Data that's referred to has no label, but is commented all the same.
+		if comment := Xref_Comment(offset); comment != "" {
+			above = append(above, comment)
+		}
+		if len(above) > 0 {
+			t.Flush()
+			fmt.Println(strings.Join(above, "\n"))
+		}
 		if instruction.Label != "" {
 			fmt.Fprintf(t, "%s:\t\t\t\n", instruction.Label)
 		}
 
 		ofst := fmt.Sprintf("%08x:", instruction.Offset)
 
+		// Long runs of data only show their first bytes.
 		var literal string
-		for _, byte_literal := range instruction.Literal {
+		for i, byte_literal := range instruction.Literal {
+			if i == MAX_LITERAL {
+				literal += "... "
+				break
+			}
 			literal += fmt.Sprintf("%02x ", byte_literal)
 		}
 
-		var asm string
-
-		if instruction.Pre != nil && instruction.Pre.Mnemonic != "" {
-			asm = instruction.Pre.Mnemonic + " "
-		}
-
-		if strings.Contains(instruction.Mnemonic, "%s") {
-			asm += fmt.Sprintf(instruction.Mnemonic, instruction.Operands)
-		} else {
-			asm += instruction.Mnemonic + " " + instruction.Operands
-		}
+		asm := Instruction_Text(instruction)
 
 		// Check for illegal addressing modes.
 		comment := ""
@@ -187,6 +1253,12 @@ func Print_Instructions() {
 			comment = "; Illegal addressing mode."
 		}
 
+		if marker, ok := Markers[offset]; ok {
+			comment = strings.TrimSpace(comment + " " + marker)
+		}
+		comment = strings.TrimSpace(comment + " " + String_Comment(instruction))
+		comment = strings.TrimSpace(comment + " " + Live_Comment(instruction))
+
 		fmt.Fprintf(t, "%s\t%s\t%s\t%s\n", ofst, literal, asm, comment)
 
 		visited[offset] = true
@@ -195,6 +1267,491 @@ func Print_Instructions() {
 
 }
 
+// Assembly text of instruction, with its prefix and operands.
+func Instruction_Text(instruction *datatypes.Instruction) string {
+	var asm string
+
+	if instruction.Pre != nil && instruction.Pre.Mnemonic != "" {
+		asm = instruction.Pre.Mnemonic + " "
+	}
+
+	// A jump table's cases are named as they are now.
+	operands := instruction.Operands
+	if targets, exists := Entries[instruction.Offset]; exists && instruction.Mnemonic == "dd" {
+		var names []string
+		for _, target := range targets {
+			names = append(names, Address_Name(target))
+		}
+		operands = strings.Join(names, ", ")
+	}
+
+	if strings.Contains(instruction.Mnemonic, "%s") {
+		asm += fmt.Sprintf(instruction.Mnemonic, operands)
+	} else {
+		asm += instruction.Mnemonic + " " + operands
+	}
+
+	return asm
+}
+
+// Print each basic block with its successors and the calls made from it.
+func Print_CFG(g *analysis.CFG) {
+	for _, block := range g.Sorted() {
+		name := ""
+		if label := block.Instructions[0].Label; label != "" {
+			name = " " + label
+		}
+		fmt.Printf("Block %08x-%08x%s, %d instructions\n", block.Start, block.End, name, len(block.Instructions))
+
+		for _, edge := range append(block.Succs, block.Calls...) {
+			target := ""
+			if inst, exists := Instructions[edge.To]; exists && inst.Label != "" {
+				target = " " + inst.Label
+			}
+			fmt.Printf("\t%-12s%08x%s\n", edge.Kind, edge.To, target)
+		}
+	}
+}
+
+// Print a graph of each function in DOT or Mermaid format.
+func Print_Graphs(g *analysis.CFG, format string) {
+	for _, fn := range Functions {
+		if format == "dot" {
+			g.WriteDOT(os.Stdout, fn, Instruction_Text)
+		} else {
+			g.WriteMermaid(os.Stdout, fn, Instruction_Text)
+		}
+	}
+}
+
+// Find the functions in the graph, starting from the entry point, function
+// symbols and unwind tables.
+func Find_Functions(g *analysis.CFG) []*analysis.Function {
+	var hints []analysis.Hint
+
+	if Image.Entry != 0 {
+		hints = append(hints, analysis.Hint{Addr: Image.Entry, Found: analysis.FOUND_ENTRY})
+	}
+	for _, sym := range Image.Symbols {
+		if !sym.Func {
+			continue
+		}
+		hint := analysis.Hint{Addr: sym.Addr, Name: sym.Name, Found: analysis.FOUND_SYMBOL}
+		if sym.Size > 0 {
+			hint.End = sym.Addr + sym.Size
+		}
+		hints = append(hints, hint)
+	}
+	for _, r := range Image.Unwind {
+		hints = append(hints, analysis.Hint{Addr: r.Start, End: r.End, Found: analysis.FOUND_UNWIND})
+	}
+
+	return analysis.FindFunctions(g, hints)
+}
+
+// Label the functions without a name sub_XXXXXXXX, in place of the
+// offset_XXXXXXXXh labels of branches to them, and rename the operands
+// naming them.
+func Name_Functions(functions []*analysis.Function) {
+	renamed := make(map[string]string)
+	for _, fn := range functions {
+		entry := Instructions[fn.Entry]
+		if entry.Label == "" || strings.HasPrefix(entry.Label, "offset_") {
+			if entry.Label != "" {
+				renamed[entry.Label] = fn.Name
+			}
+			entry.Label = fn.Name
+		}
+	}
+	for _, instruction := range Instructions {
+		if name, ok := renamed[instruction.Operands]; ok {
+			instruction.Operands = name
+		}
+	}
+}
+
+// Build the call graph of the functions found, with imported functions as
+// leaves.
+func Build_Call_Graph() *analysis.CallGraph {
+	imports := make(map[int]string)
+	for _, sym := range Image.Symbols {
+		if sym.Import {
+			imports[sym.Addr] = sym.Name
+		}
+	}
+
+	// In an object, a call to a function it doesn't define is left to a
+	// relocation, and goes wherever the unpatched field points. Name that
+	// after the symbol, as an import.
+	for offset, instruction := range Instructions {
+		if !analysis.IsCall(instruction) || instruction.HasTarget {
+			continue
+		}
+		if reloc := Image.RelocationIn(offset, len(instruction.Literal)); reloc != nil && !reloc.Defined && reloc.PCRel {
+			imports[instruction.Target] = reloc.Symbol
+		}
+	}
+
+	return analysis.BuildCallGraph(Functions, imports, Instruction_Text)
+}
+
+// Most paths to list for -paths, as there can be very many.
+const MAX_PATHS = 100
+
+// Print the call graph, or answer a -callers or -paths query about it.
+func Print_Call_Graph(cg *analysis.CallGraph) {
+	find := func(name string) *analysis.Node {
+		node := cg.Find(name)
+		if node == nil {
+			log.Fatalf("Error: no function %s", name)
+		}
+		return node
+	}
+
+	switch {
+
+	case callers != "":
+		for _, call := range find(callers).Callers {
+			fmt.Printf("%08x\t%s\n", call.Site, call.Caller.Name)
+		}
+
+	case paths != "":
+		to := find(paths)
+
+		var starts []*analysis.Node
+		if from != "" {
+			starts = append(starts, find(from))
+		} else if entry, exists := cg.Nodes[Image.Entry]; exists && Image.Entry != 0 {
+			starts = append(starts, entry)
+		} else {
+			starts = cg.Roots()
+		}
+		if len(starts) == 0 {
+			log.Fatalf("Error: no entry point or uncalled function to find paths from")
+		}
+
+		found := 0
+		for _, start := range starts {
+			for _, path := range cg.Paths(start, to, MAX_PATHS-found) {
+				var names []string
+				for _, node := range path {
+					names = append(names, node.Name)
+				}
+				fmt.Println(strings.Join(names, " -> "))
+				found++
+			}
+		}
+
+	case format == "dot":
+		cg.WriteDOT(os.Stdout)
+
+	case format == "json":
+		if err := cg.WriteJSON(os.Stdout); err != nil {
+			log.Fatalf("Error writing call graph: %s", err)
+		}
+
+	case format == "text":
+		cg.WriteText(os.Stdout)
+
+	default:
+		log.Fatalf("Error: the call graph can't be written as %s, only as text, dot or json", format)
+	}
+}
+
+// Comment on the registers live before instruction, and any it writes that
+// are never read, e.g. ; live: eax, esi; dead: ecx.
+func Live_Comment(instruction *datatypes.Instruction) string {
+	if Liveness == nil {
+		return ""
+	}
+	before, ok := Liveness.Before[instruction.Offset]
+	if !ok {
+		return ""
+	}
+
+	comment := "; live: " + before.String()
+	if dead := Liveness.Dead(instruction); dead != 0 {
+		comment += "; dead: " + dead.String()
+	}
+	return comment
+}
+
+// Print each register definition with the instructions that read the value
+// it gives, in order.
+func Print_DefUse(g *analysis.CFG) {
+	chains := g.DefUse()
+
+	var offsets []int
+	for offset := range chains {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	for _, offset := range offsets {
+		for _, chain := range chains[offset] {
+			var uses []string
+			for _, use := range chain.Uses {
+				uses = append(uses, fmt.Sprintf("%08x", use))
+			}
+			if len(uses) == 0 {
+				uses = append(uses, "; unused")
+			}
+			fmt.Printf("%08x\t%s\t%s\n", chain.Def, datatypes.Registers[chain.Reg], strings.Join(uses, " "))
+		}
+	}
+}
+
+// Follow the stack through each function found, naming the arguments and
+// locals its memory operands reach, e.g. [ ebp+var_8 ], and commenting on
+// its frame and any return that leaves the stack unbalanced.
+func Analyze_Frames() []*analysis.Frame {
+	frames := Stack_Frames()
+	for _, frame := range frames {
+		fn := frame.Function
+
+		header := "; Frame: esp only"
+		if frame.FramePointer {
+			header = "; Frame: ebp"
+		}
+		if frame.Locals > 0 {
+			header += fmt.Sprintf(", %d bytes of locals", frame.Locals)
+		}
+		Headers[fn.Entry] = append(Headers[fn.Entry], header)
+
+		for offset, v := range frame.Vars {
+			instruction := Instructions[offset]
+			old := datatypes.StringifyRM(instruction.Modrm, instruction.Displacement)
+			named := fmt.Sprintf("[ %s+%s ]", datatypes.Registers[v.Base], v.Name)
+			instruction.Operands = strings.Replace(instruction.Operands, old, named, 1)
+		}
+
+		for offset, delta := range frame.Imbalances {
+			marker := fmt.Sprintf("; Stack imbalance: %d bytes left on the stack", -delta)
+			if delta > 0 {
+				marker = fmt.Sprintf("; Stack imbalance: %d bytes too many popped", delta)
+			}
+			Markers[offset] = strings.TrimSpace(Markers[offset] + " " + marker)
+		}
+	}
+
+	return frames
+}
+
+// Follow the stack through each function found, in order, knowing how much
+// each pops off the stack on return.
+func Stack_Frames() []*analysis.Frame {
+	pops := make(map[int]int)
+	for _, fn := range Functions {
+		pops[fn.Entry] = fn.Pops()
+	}
+
+	var frames []*analysis.Frame
+	for _, fn := range Functions {
+		frames = append(frames, analysis.AnalyzeStack(fn, pops))
+	}
+	return frames
+}
+
+// Comment above each function found on its calling convention and how many
+// arguments it takes, e.g. ; Convention: fastcall, 3 args (ecx, edx).
+func Infer_Conventions() {
+	frames := Frames
+	if frames == nil {
+		frames = Stack_Frames()
+	}
+	cleanups := Graph.Cleanups()
+
+	for _, frame := range frames {
+		fn := frame.Function
+		conv := analysis.InferConvention(fn, frame, cleanups)
+
+		header := fmt.Sprintf("; Convention: %s, %d args", conv.Name, conv.Args)
+		if conv.Args == 1 {
+			header = fmt.Sprintf("; Convention: %s, 1 arg", conv.Name)
+		}
+		if conv.Registers != 0 {
+			header += fmt.Sprintf(" (%s)", conv.Registers)
+		}
+		Headers[fn.Entry] = append(Headers[fn.Entry], header)
+	}
+}
+
+// Print the functions found, with their extents and how they were found.
+func Print_Functions() {
+	t := new(tabwriter.Writer)
+	t.Init(os.Stdout, 8, 8, 1, ' ', 0)
+	defer t.Flush()
+
+	fmt.Fprintf(t, "Start\tEnd\tSize\tFound\tName\n")
+	for _, fn := range Functions {
+		fmt.Fprintf(t, "%08x\t%08x\t%d\t%s\t%s\n", fn.Entry, fn.End, fn.Size(), fn.Found, fn.Name)
+	}
+}
+
+// Put the source lines that each run of code was compiled from above it, as
+// objdump -S does. If a source file can't be read, just its name and line
+// number are shown.
+func Interleave_Source() {
+	sources := make(map[string][]string)
+	var last *loaders.Line
+
+	for _, line := range Image.Lines {
+		if inst, exists := Instructions[line.Addr]; !exists || len(inst.Literal) == 0 {
+			continue
+		}
+		if last != nil && last.File == line.File && last.Line == line.Line {
+			continue
+		}
+
+		text, read := sources[line.File]
+		if !read {
+			text = Read_Source(line.File)
+			sources[line.File] = text
+		}
+
+		// Also show lines skipped since the last one, such as declarations,
+		// as long as it's a short way back.
+		first := line.Line
+		if last != nil && last.File == line.File && last.Line < line.Line && line.Line-last.Line <= 8 {
+			first = last.Line + 1
+		}
+
+		header := append(Headers[line.Addr], fmt.Sprintf("; %s:%d", line.File, line.Line))
+		for n := first; n <= line.Line && n <= len(text); n++ {
+			header = append(header, strings.TrimSpace("; "+text[n-1]))
+		}
+		Headers[line.Addr] = header
+
+		last = line
+	}
+}
+
+// Lines of a source file, looking in the current directory if it's not where
+// it was compiled. Returns nil if it can't be found.
+func Read_Source(name string) []string {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		if data, err = os.ReadFile(filepath.Base(name)); err != nil {
+			return nil
+		}
+	}
+
+	text := strings.ReplaceAll(string(data), "\t", "    ")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Is addr, the immediate of instruction, an address? Only if a relocation or
+// symbol says so, or it points at a labelled line or the start of some data.
+func Immediate_Address(instruction *datatypes.Instruction, addr int) bool {
+	field := instruction.Offset + len(instruction.Literal) - len(instruction.Immediate)
+	if Image.RelocationIn(field, len(instruction.Immediate)) != nil {
+		return true
+	}
+	if sym, offset := Image.SymbolAt(addr); sym != nil && offset == 0 {
+		return true
+	}
+	inst, exists := Instructions[addr]
+	return exists && (inst.Label != "" || analysis.Directives[inst.Mnemonic])
+}
+
+// Most references to list above a label.
+const MAX_XREFS = 8
+
+// Comment listing the references to offset, e.g.
+// "; XREF: 0x00401020 (j), 0x00401500 (c)", or "" if there are none.
+func Xref_Comment(offset int) string {
+	if Xrefs == nil {
+		return ""
+	}
+
+	refs := Xrefs.To[offset]
+	if len(refs) == 0 {
+		return ""
+	}
+
+	var list []string
+	for i, ref := range refs {
+		if i == MAX_XREFS {
+			list = append(list, fmt.Sprintf("... %d more", len(refs)-i))
+			break
+		}
+		list = append(list, fmt.Sprintf("0x%08x (%s)", ref.From, ref.Kind))
+	}
+
+	return "; XREF: " + strings.Join(list, ", ")
+}
+
+// Replace a relocated displacement or immediate in the operands with the
+// symbol it refers to, e.g. "call printf". Returns whether the instruction
+// still has a known branch target, which it doesn't if the symbol is
+// undefined.
+func Relocate_Operands(instruction *datatypes.Instruction, is_offset bool) bool {
+	end := instruction.Offset + len(instruction.Literal)
+	imm := end - len(instruction.Immediate)
+	disp := imm - len(instruction.Displacement)
+
+	for addr := instruction.Offset; addr < end; addr++ {
+		reloc, exists := Image.Relocations[addr]
+		if !exists {
+			continue
+		}
+
+		// Prefer a real symbol to a section-relative name like ".bss+0x4".
+		target := reloc.Target(end)
+		if reloc.Defined && !reloc.Got && !reloc.GotOff && !reloc.PCRel {
+			if name := Image.SymbolName(reloc.Value + reloc.Addend); name != "" {
+				target = name
+			}
+		}
+
+		switch {
+
+		case addr == disp && is_offset:
+			instruction.Operands = target
+			return reloc.Defined && !reloc.Got && !reloc.GotOff
+
+		// An absolute address is written in full, and a displacement from
+		// a register with its sign.
+		case addr == disp && len(instruction.Displacement) > 0:
+			old := datatypes.StringifyIntegerBytes(instruction.Displacement)
+			if !Absolute_Displacement(instruction.Modrm) {
+				old, target = datatypes.StringifyDisplacement(instruction.Displacement), "+"+target
+			}
+			instruction.Operands = strings.Replace(instruction.Operands, old, target, 1)
+
+		case addr == imm && len(instruction.Immediate) > 0:
+			old := datatypes.StringifyIntegerBytes(instruction.Immediate)
+			if i := strings.LastIndex(instruction.Operands, old); i >= 0 {
+				instruction.Operands = instruction.Operands[:i] + target + instruction.Operands[i+len(old):]
+			}
+		}
+	}
+
+	return is_offset
+}
+
+// Is the displacement of an instruction with this MODRM an absolute address,
+// rather than an offset from a register? It is with no MODRM, as in the moffs
+// forms of mov, and with Mod 0, where only [disp32], or a SIB with no base,
+// has one at all. In 16-bit code that's [disp16].
+func Absolute_Displacement(modrm *datatypes.ModRm) bool {
+	return modrm == nil || modrm.Mod == datatypes.AM_REG
+}
+
+// Name an absolute memory operand after the symbol at its address, such as
+// an import slot in "call [ __imp_CreateFileW ]".
+func Symbolize_Memory(instruction *datatypes.Instruction) {
+	addr, ok := analysis.MemoryAddress(instruction)
+	if !ok {
+		return
+	}
+
+	if sym, offset := Image.SymbolAt(addr); sym != nil && offset == 0 {
+		old := datatypes.StringifyIntegerBytes(instruction.Displacement)
+		instruction.Operands = strings.Replace(instruction.Operands, old, sym.Name, 1)
+	}
+}
+
 func ReadAll(b *bytes.Buffer, f *os.File) error {
 	defer f.Close()
 	_, err := io.Copy(b, f)
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 )
 
 // OpCode
@@ -20,6 +21,7 @@ type OpCode struct {
 	Extension    int
 	DispSize     int
 	ImmSize      int
+	Effect       *Effect // What it does with registers, memory and flags.
 }
 
 var (
@@ -32,6 +34,7 @@ var (
 	OpCodesPrefixed = make(map[byte]*OpCode)
 
 	Op81 = make(map[int]*OpCode)
+	Op83 = make(map[int]*OpCode)
 	OpFF = make(map[int]*OpCode)
 	OpAE = make(map[int]*OpCode)
 	OpF7 = make(map[int]*OpCode)
@@ -60,6 +63,7 @@ func init() {
 	Prefixes[0xF2] = Repne
 
 	OpCodesExt[0x81] = Op81
+	OpCodesExt[0x83] = Op83
 	OpCodesExt[0xFF] = OpFF
 	OpCodesExt[0xAE] = OpAE
 	OpCodesExt[0xF7] = OpF7
@@ -89,6 +93,16 @@ func init() {
 			DispSize:     0,
 			ImmSize:      4,
 		},
+		{
+			Literal:      0x83,
+			Mnemonic:     "add",
+			Encoder:      encoders.MI{},
+			ModrmReq:     true,
+			ExtensionReq: true,
+			Extension:    0,
+			DispSize:     0,
+			ImmSize:      1,
+		},
 		{
 			Literal:      0x01,
 			Mnemonic:     "add",
@@ -128,6 +142,16 @@ func init() {
 			DispSize:     0,
 			ImmSize:      4,
 		},
+		{
+			Literal:      0x83,
+			Mnemonic:     "and",
+			Encoder:      encoders.MI{},
+			ModrmReq:     true,
+			ExtensionReq: true,
+			Extension:    4,
+			DispSize:     0,
+			ImmSize:      1,
+		},
 		{
 			Literal:      0x21,
 			Mnemonic:     "and",
@@ -201,6 +225,16 @@ func init() {
 			DispSize:     0,
 			ImmSize:      4,
 		},
+		{
+			Literal:      0x83,
+			Mnemonic:     "cmp",
+			Encoder:      encoders.MI{},
+			ModrmReq:     true,
+			ExtensionReq: true,
+			Extension:    7,
+			DispSize:     0,
+			ImmSize:      1,
+		},
 		{
 			Literal:      0x39,
 			Mnemonic:     "cmp",
@@ -241,6 +275,17 @@ func init() {
 			ImmSize:      0,
 		},
 
+		// ENTER
+		{
+			Literal:      0xC8,
+			Mnemonic:     "enter",
+			Encoder:      encoders.II{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     0,
+			ImmSize:      2,
+		},
+
 		// IDIV
 		{
 			Literal:      0xF7,
@@ -377,6 +422,300 @@ func init() {
 			ImmSize:      0,
 		},
 
+		// JO
+		{
+			Literal:      0x70,
+			Mnemonic:     "jo",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x80,
+			Mnemonic:     "jo",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JNO
+		{
+			Literal:      0x71,
+			Mnemonic:     "jno",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x81,
+			Mnemonic:     "jno",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JB
+		{
+			Literal:      0x72,
+			Mnemonic:     "jb",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x82,
+			Mnemonic:     "jb",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JNB
+		{
+			Literal:      0x73,
+			Mnemonic:     "jnb",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x83,
+			Mnemonic:     "jnb",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JBE
+		{
+			Literal:      0x76,
+			Mnemonic:     "jbe",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x86,
+			Mnemonic:     "jbe",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JA
+		{
+			Literal:      0x77,
+			Mnemonic:     "ja",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x87,
+			Mnemonic:     "ja",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JS
+		{
+			Literal:      0x78,
+			Mnemonic:     "js",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x88,
+			Mnemonic:     "js",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JNS
+		{
+			Literal:      0x79,
+			Mnemonic:     "jns",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x89,
+			Mnemonic:     "jns",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JP
+		{
+			Literal:      0x7A,
+			Mnemonic:     "jp",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x8A,
+			Mnemonic:     "jp",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JNP
+		{
+			Literal:      0x7B,
+			Mnemonic:     "jnp",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x8B,
+			Mnemonic:     "jnp",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JL
+		{
+			Literal:      0x7C,
+			Mnemonic:     "jl",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x8C,
+			Mnemonic:     "jl",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JGE
+		{
+			Literal:      0x7D,
+			Mnemonic:     "jge",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x8D,
+			Mnemonic:     "jge",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JLE
+		{
+			Literal:      0x7E,
+			Mnemonic:     "jle",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x8E,
+			Mnemonic:     "jle",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
+		// JG
+		{
+			Literal:      0x7F,
+			Mnemonic:     "jg",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     1,
+			ImmSize:      0,
+		},
+		{
+			Literal:      0x8F,
+			Mnemonic:     "jg",
+			Encoder:      encoders.D{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			PrefixReq:    true,
+			DispSize:     4,
+			ImmSize:      0,
+		},
+
 		// LEA
 		{
 			Literal:      0x8D,
@@ -388,6 +727,17 @@ func init() {
 			ImmSize:      0,
 		},
 
+		// LEAVE
+		{
+			Literal:      0xC9,
+			Mnemonic:     "leave",
+			Encoder:      encoders.NP{},
+			ModrmReq:     false,
+			ExtensionReq: false,
+			DispSize:     0,
+			ImmSize:      0,
+		},
+
 		// MOV
 		{
 			Literal:      0xB8,
@@ -505,6 +855,16 @@ func init() {
 			DispSize:     0,
 			ImmSize:      4,
 		},
+		{
+			Literal:      0x83,
+			Mnemonic:     "or",
+			Encoder:      encoders.MI{},
+			ModrmReq:     true,
+			ExtensionReq: true,
+			Extension:    1,
+			DispSize:     0,
+			ImmSize:      1,
+		},
 		{
 			Literal:      0x09,
 			Mnemonic:     "or",
@@ -688,6 +1048,16 @@ func init() {
 			DispSize:     0,
 			ImmSize:      4,
 		},
+		{
+			Literal:      0x83,
+			Mnemonic:     "sbb",
+			Encoder:      encoders.MI{},
+			ModrmReq:     true,
+			ExtensionReq: true,
+			Extension:    3,
+			DispSize:     0,
+			ImmSize:      1,
+		},
 		{
 			Literal:      0x19,
 			Mnemonic:     "sbb",
@@ -727,6 +1097,16 @@ func init() {
 			DispSize:     0,
 			ImmSize:      4,
 		},
+		{
+			Literal:      0x83,
+			Mnemonic:     "sub",
+			Encoder:      encoders.MI{},
+			ModrmReq:     true,
+			ExtensionReq: true,
+			Extension:    5,
+			DispSize:     0,
+			ImmSize:      1,
+		},
 		{
 			Literal:      0x29,
 			Mnemonic:     "sub",
@@ -796,6 +1176,16 @@ func init() {
 			DispSize:     0,
 			ImmSize:      4,
 		},
+		{
+			Literal:      0x83,
+			Mnemonic:     "xor",
+			Encoder:      encoders.MI{},
+			ModrmReq:     true,
+			ExtensionReq: true,
+			Extension:    6,
+			DispSize:     0,
+			ImmSize:      1,
+		},
 		{
 			Literal:      0x31,
 			Mnemonic:     "xor",
@@ -840,7 +1230,21 @@ func (o *OpCode) Encode(data *bytes.Buffer, inst *datatypes.Instruction) error {
 	inst.Mnemonic = o.Mnemonic
 	inst.DispSize = o.DispSize
 	inst.ImmSize = o.ImmSize
-	err = o.Encoder.Encode(data, inst)
+
+	// 16-bit code has 16-bit operands wherever 32-bit code has 32-bit ones.
+	if inst.Bits == datatypes.BITS_16 {
+		inst.Mnemonic = strings.Replace(inst.Mnemonic, "eax", "ax", 1)
+		if inst.DispSize == 4 {
+			inst.DispSize = 2
+		}
+		if inst.ImmSize == 4 {
+			inst.ImmSize = 2
+		}
+	}
+
+	if err = o.Encoder.Encode(data, inst); err == nil {
+		inst.Semantics = o.Semantics(inst)
+	}
 	return err
 }
 
@@ -0,0 +1,253 @@
+package operations
+
+import (
+	"disassembler/datatypes"
+	"strings"
+)
+
+// How an operation uses an operand
+type Access int
+
+const (
+	ACCESS_NONE       = Access(0)
+	ACCESS_READ       = Access(1)
+	ACCESS_WRITE      = Access(2)
+	ACCESS_READ_WRITE = ACCESS_READ | ACCESS_WRITE
+)
+
+// What an operation does: how it uses its first operand, the rest only being
+// read, the registers and memory it uses without naming them, and the flags
+// it reads and writes.
+type Effect struct {
+	Dest      Access
+	Address   bool // The memory operand is only an address, as in lea.
+	Reads     datatypes.RegSet
+	Writes    datatypes.RegSet
+	MemRead   bool
+	MemWrite  bool
+	Tested    datatypes.Flags
+	Set       datatypes.Flags
+	Cleared   datatypes.Flags
+	Undefined datatypes.Flags
+}
+
+var (
+	esp     = datatypes.RegSetOf(datatypes.REG_ESP)
+	eax_edx = datatypes.RegSetOf(datatypes.REG_EAX, datatypes.REG_EDX)
+	esi_edi = datatypes.RegSetOf(datatypes.REG_ESI, datatypes.REG_EDI)
+	esp_ebp = datatypes.RegSetOf(datatypes.REG_ESP, datatypes.REG_EBP)
+
+	arithmetic = &Effect{Dest: ACCESS_READ_WRITE, Set: datatypes.FLAGS_OSZAPC}
+	logic      = &Effect{
+		Dest:      ACCESS_READ_WRITE,
+		Set:       datatypes.FLAG_SF | datatypes.FLAG_ZF | datatypes.FLAG_PF,
+		Cleared:   datatypes.FLAG_OF | datatypes.FLAG_CF,
+		Undefined: datatypes.FLAG_AF,
+	}
+	shift = &Effect{
+		Dest:      ACCESS_READ_WRITE,
+		Set:       datatypes.FLAGS_OSZAPC &^ datatypes.FLAG_AF,
+		Undefined: datatypes.FLAG_AF,
+	}
+	multiply = &Effect{
+		Dest:      ACCESS_READ_WRITE,
+		Set:       datatypes.FLAG_OF | datatypes.FLAG_CF,
+		Undefined: datatypes.FLAG_SF | datatypes.FLAG_ZF | datatypes.FLAG_AF | datatypes.FLAG_PF,
+	}
+)
+
+// Effects of operations, by mnemonic. Conditional jumps are added in init,
+// from the flags they test.
+var Effects = map[string]*Effect{
+	"add": arithmetic,
+	"sub": arithmetic,
+	"neg": arithmetic,
+	"sbb": {Dest: ACCESS_READ_WRITE, Tested: datatypes.FLAG_CF, Set: datatypes.FLAGS_OSZAPC},
+	"cmp": {Dest: ACCESS_READ, Set: datatypes.FLAGS_OSZAPC},
+	"inc": {Dest: ACCESS_READ_WRITE, Set: datatypes.FLAGS_OSZAPC &^ datatypes.FLAG_CF},
+	"dec": {Dest: ACCESS_READ_WRITE, Set: datatypes.FLAGS_OSZAPC &^ datatypes.FLAG_CF},
+
+	"and":  logic,
+	"or":   logic,
+	"xor":  logic,
+	"test": {Dest: ACCESS_READ, Set: logic.Set, Cleared: logic.Cleared, Undefined: logic.Undefined},
+	"not":  {Dest: ACCESS_READ_WRITE},
+
+	"sal": shift,
+	"sar": shift,
+	"shr": shift,
+
+	// The one operand forms multiply or divide edx:eax.
+	"imul": multiply,
+	"mul":  {Dest: ACCESS_READ, Reads: datatypes.RegSetOf(datatypes.REG_EAX), Writes: eax_edx, Set: multiply.Set, Undefined: multiply.Undefined},
+	"idiv": {Dest: ACCESS_READ, Reads: eax_edx, Writes: eax_edx, Undefined: datatypes.FLAGS_OSZAPC},
+
+	"mov":     {Dest: ACCESS_WRITE},
+	"lea":     {Dest: ACCESS_WRITE, Address: true},
+	"nop":     {},
+	"clflush": {Dest: ACCESS_READ, Address: true},
+	"out":     {},
+
+	"push": {Dest: ACCESS_READ, Reads: esp, Writes: esp, MemWrite: true},
+	"pop":  {Dest: ACCESS_WRITE, Reads: esp, Writes: esp, MemRead: true},
+	"call": {Dest: ACCESS_READ, Reads: esp, Writes: esp, MemWrite: true},
+	"jmp":  {Dest: ACCESS_READ},
+	"retn": {Reads: esp, Writes: esp, MemRead: true},
+	"retf": {Reads: esp, Writes: esp, MemRead: true},
+
+	// enter pushes ebp and points it at the new frame, and leave undoes it.
+	"enter": {Reads: esp_ebp, Writes: esp_ebp, MemWrite: true},
+	"leave": {Reads: datatypes.RegSetOf(datatypes.REG_EBP), Writes: esp_ebp, MemRead: true},
+
+	"movsd": {Reads: esi_edi, Writes: esi_edi, MemRead: true, MemWrite: true, Tested: datatypes.FLAG_DF},
+	"cmpsd": {Reads: esi_edi, Writes: esi_edi, MemRead: true, Tested: datatypes.FLAG_DF, Set: datatypes.FLAGS_OSZAPC},
+}
+
+// Flags tested by each condition code
+var ConditionFlags = map[string]datatypes.Flags{
+	"o":  datatypes.FLAG_OF,
+	"b":  datatypes.FLAG_CF,
+	"z":  datatypes.FLAG_ZF,
+	"be": datatypes.FLAG_CF | datatypes.FLAG_ZF,
+	"s":  datatypes.FLAG_SF,
+	"p":  datatypes.FLAG_PF,
+	"l":  datatypes.FLAG_SF | datatypes.FLAG_OF,
+	"le": datatypes.FLAG_ZF | datatypes.FLAG_SF | datatypes.FLAG_OF,
+}
+
+func init() {
+	inverse := map[string]string{"o": "no", "b": "nb", "z": "nz", "be": "a", "s": "ns", "p": "np", "l": "ge", "le": "g"}
+	for cc, flags := range ConditionFlags {
+		Effects["j"+cc] = &Effect{Tested: flags}
+		Effects["j"+inverse[cc]] = &Effect{Tested: flags}
+	}
+
+	for _, op := range allOps {
+		op.Effect = EffectOf(op)
+	}
+}
+
+// Effect of an operation, from its mnemonic, or nil if it's unknown.
+func EffectOf(op *OpCode) *Effect {
+	mnemonic := strings.Fields(op.Mnemonic)[0]
+
+	// imul with two or three operands writes only its first.
+	if mnemonic == "imul" && op.Encoder.Encoding() == "RMI" {
+		return &Effect{Dest: ACCESS_WRITE, Set: multiply.Set, Undefined: multiply.Undefined}
+	}
+	if mnemonic == "imul" && op.Encoder.Encoding() == "M" {
+		return Effects["mul"]
+	}
+
+	return Effects[mnemonic]
+}
+
+// Operand of an instruction, as far as semantics go: a register, memory
+// addressed through some registers, or an immediate, which is neither.
+type operand struct {
+	reg     datatypes.Register
+	is_reg  bool
+	address datatypes.RegSet
+	is_mem  bool
+}
+
+// Operands of inst, in order, by how its encoding lays them out.
+func (o *OpCode) operands(inst *datatypes.Instruction) []operand {
+	rm := func() operand {
+		if inst.Modrm.Mod == datatypes.AM_DIRECT {
+			return operand{reg: inst.Modrm.RM, is_reg: true}
+		}
+		return operand{address: inst.Modrm.AddressRegisters(), is_mem: true}
+	}
+	reg := func(r datatypes.Register) operand {
+		return operand{reg: r, is_reg: true}
+	}
+	imm := operand{}
+
+	switch o.Encoder.Encoding() {
+	case "M":
+		return []operand{rm()}
+	case "MI":
+		return []operand{rm(), imm}
+	case "MR":
+		return []operand{rm(), reg(inst.Modrm.Reg)}
+	case "RM":
+		return []operand{reg(inst.Modrm.Reg), rm()}
+	case "RMI":
+		return []operand{reg(inst.Modrm.Reg), rm(), imm}
+	case "O":
+		return []operand{reg(datatypes.Register(int(inst.Op & 7)))}
+	case "OI":
+		return []operand{reg(datatypes.Register(int(inst.Op & 7))), imm}
+	case "I":
+		// The accumulator forms name eax in the mnemonic.
+		switch {
+		case strings.HasSuffix(o.Mnemonic, ", eax"):
+			return []operand{imm, reg(datatypes.REG_EAX)}
+		case strings.Contains(o.Mnemonic, "eax"):
+			return []operand{reg(datatypes.REG_EAX), imm}
+		}
+		return []operand{imm}
+	}
+	return nil
+}
+
+// Semantics of inst, decoded as this operation, or nil if they're unknown.
+func (o *OpCode) Semantics(inst *datatypes.Instruction) *datatypes.Semantics {
+	e := o.Effect
+	if e == nil {
+		return nil
+	}
+	if inst.Modrm == nil && (o.ModrmReq || o.Encoder.Encoding() == "M") {
+		return nil
+	}
+
+	s := &datatypes.Semantics{
+		Reads:     e.Reads,
+		Writes:    e.Writes,
+		MemRead:   e.MemRead,
+		MemWrite:  e.MemWrite,
+		Tested:    e.Tested,
+		Set:       e.Set,
+		Cleared:   e.Cleared,
+		Undefined: e.Undefined,
+	}
+
+	operands := o.operands(inst)
+	for i, op := range operands {
+		access := ACCESS_READ
+		if i == 0 {
+			access = e.Dest
+		}
+
+		switch {
+		case op.is_reg:
+			if access&ACCESS_READ != 0 {
+				s.Reads |= datatypes.RegSetOf(op.reg)
+			}
+			if access&ACCESS_WRITE != 0 {
+				s.Writes |= datatypes.RegSetOf(op.reg)
+			}
+		case op.is_mem:
+			s.Reads |= op.address
+			if !e.Address {
+				s.MemRead = s.MemRead || access&ACCESS_READ != 0
+				s.MemWrite = s.MemWrite || access&ACCESS_WRITE != 0
+			}
+		}
+	}
+
+	// xor or sub of a register from itself zeroes it, whatever it held.
+	if len(operands) == 2 && operands[0].is_reg && operands[1].is_reg && operands[0].reg == operands[1].reg &&
+		(o.Mnemonic == "xor" || o.Mnemonic == "sub") {
+		s.Reads &^= datatypes.RegSetOf(operands[0].reg)
+	}
+
+	// repne repeats ecx times.
+	if inst.Pre == Repne {
+		s.Reads |= datatypes.RegSetOf(datatypes.REG_ECX)
+		s.Writes |= datatypes.RegSetOf(datatypes.REG_ECX)
+	}
+
+	return s
+}